@@ -1,6 +1,9 @@
 package config
 
 import (
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -15,6 +18,99 @@ type Config struct {
 	FrontendUrl             string `mapstructure:"FRONTEND_URL"`
 	MailgunAPIKey           string `mapstructure:"MAILGUN_API_KEY"`
 	MailgunDomain           string `mapstructure:"MAILGUN_DOMAIN"`
+	BackendUrl              string `mapstructure:"BACKEND_URL"` // public address of this server, used in emailed links
+
+	// Optional identity providers. A provider is only offered when its
+	// client ID (or, for Steam, its return URL) is configured.
+	DiscordOauthClientID     string `mapstructure:"DISCORD_OAUTH_CLIENT_ID"`
+	DiscordOauthClientSecret string `mapstructure:"DISCORD_OAUTH_CLIENT_SECRET"`
+	DiscordOauthRedirectUrl  string `mapstructure:"DISCORD_OAUTH_REDIRECT_URL"`
+	GithubOauthClientID      string `mapstructure:"GITHUB_OAUTH_CLIENT_ID"`
+	GithubOauthClientSecret  string `mapstructure:"GITHUB_OAUTH_CLIENT_SECRET"`
+	GithubOauthRedirectUrl   string `mapstructure:"GITHUB_OAUTH_REDIRECT_URL"`
+	SteamReturnUrl           string `mapstructure:"STEAM_RETURN_URL"`
+	SteamRealm               string `mapstructure:"STEAM_REALM"`
+	SteamAPIKey              string `mapstructure:"STEAM_API_KEY"`
+
+	// Tokens are signed with the key named by JwtActiveKeyID. JwtKeys lists
+	// every accepted key as "kid:secret" pairs separated by commas, so an old
+	// key can stay valid for verification while a new one is rolled out.
+	// JwtSecret remains usable as the key with id "default".
+	JwtKeys        string `mapstructure:"JWT_KEYS"`
+	JwtActiveKeyID string `mapstructure:"JWT_ACTIVE_KEY_ID"`
+	JwtIssuer      string `mapstructure:"JWT_ISSUER"`
+	JwtAudience    string `mapstructure:"JWT_AUDIENCE"`
+
+	// Magic login links are valid for MagicLinkTTL and can be requested
+	// LoginRateLimit times per LoginRateWindow from one client.
+	MagicLinkTTL    time.Duration `mapstructure:"MAGIC_LINK_TTL"`
+	LoginRateLimit  int           `mapstructure:"LOGIN_RATE_LIMIT"`
+	LoginRateWindow time.Duration `mapstructure:"LOGIN_RATE_WINDOW"`
+
+	// InviteTTL is how long a game invitation can be accepted.
+	InviteTTL time.Duration `mapstructure:"INVITE_TTL"`
+
+	// Access tokens are short lived; clients renew them with a rotating
+	// refresh token. With AuthCookieMode the tokens are delivered as HttpOnly
+	// cookies instead of being appended to the frontend redirect URL.
+	AccessTokenTTL  time.Duration `mapstructure:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `mapstructure:"REFRESH_TOKEN_TTL"`
+	AuthCookieMode  bool          `mapstructure:"AUTH_COOKIE_MODE"`
+
+	// Rate limits are applied per authenticated user (or per client IP for
+	// anonymous requests) within each rate limited route group.
+	SaveRateLimit       int           `mapstructure:"SAVE_RATE_LIMIT"`
+	SaveRateWindow      time.Duration `mapstructure:"SAVE_RATE_WINDOW"`
+	MessageRateLimit    int           `mapstructure:"MESSAGE_RATE_LIMIT"`
+	MessageRateWindow   time.Duration `mapstructure:"MESSAGE_RATE_WINDOW"`
+	RateLimitIdleExpiry time.Duration `mapstructure:"RATE_LIMIT_IDLE_EXPIRY"`
+
+	// TrustedProxies lists the addresses or CIDR ranges, separated by commas,
+	// of reverse proxies whose X-Forwarded-For header names the client IP.
+	// Without any, the client IP is always the address of the connection.
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+
+	// Deleted games can be restored during the grace period; afterwards the
+	// purge job removes their rows and save files.
+	DeletedGameGracePeriod time.Duration `mapstructure:"DELETED_GAME_GRACE_PERIOD"`
+	PurgeInterval          time.Duration `mapstructure:"PURGE_INTERVAL"`
+
+	// ClockCheckInterval is how often chess clocks are checked for players
+	// who have run out of time.
+	ClockCheckInterval time.Duration `mapstructure:"CLOCK_CHECK_INTERVAL"`
+}
+
+// setDefaults registers fallback values so optional settings can be omitted
+// from config.yaml and still be overridden from the environment.
+func setDefaults() {
+	for _, key := range []string{
+		"DISCORD_OAUTH_CLIENT_ID", "DISCORD_OAUTH_CLIENT_SECRET", "DISCORD_OAUTH_REDIRECT_URL",
+		"GITHUB_OAUTH_CLIENT_ID", "GITHUB_OAUTH_CLIENT_SECRET", "GITHUB_OAUTH_REDIRECT_URL",
+		"STEAM_RETURN_URL", "STEAM_REALM", "STEAM_API_KEY",
+	} {
+		viper.SetDefault(key, "")
+	}
+	viper.SetDefault("JWT_KEYS", "")
+	viper.SetDefault("JWT_ACTIVE_KEY_ID", "")
+	viper.SetDefault("JWT_ISSUER", "async-multiplayer")
+	viper.SetDefault("JWT_AUDIENCE", "async-multiplayer-api")
+	viper.SetDefault("BACKEND_URL", "http://localhost:8080")
+	viper.SetDefault("MAGIC_LINK_TTL", 15*time.Minute)
+	viper.SetDefault("LOGIN_RATE_LIMIT", 5)
+	viper.SetDefault("LOGIN_RATE_WINDOW", 15*time.Minute)
+	viper.SetDefault("INVITE_TTL", 7*24*time.Hour)
+	viper.SetDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+	viper.SetDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	viper.SetDefault("AUTH_COOKIE_MODE", false)
+	viper.SetDefault("SAVE_RATE_LIMIT", 10)
+	viper.SetDefault("SAVE_RATE_WINDOW", time.Minute)
+	viper.SetDefault("MESSAGE_RATE_LIMIT", 100)
+	viper.SetDefault("MESSAGE_RATE_WINDOW", time.Minute)
+	viper.SetDefault("RATE_LIMIT_IDLE_EXPIRY", 10*time.Minute)
+	viper.SetDefault("TRUSTED_PROXIES", "")
+	viper.SetDefault("DELETED_GAME_GRACE_PERIOD", 30*24*time.Hour)
+	viper.SetDefault("PURGE_INTERVAL", time.Hour)
+	viper.SetDefault("CLOCK_CHECK_INTERVAL", time.Minute)
 }
 
 // LoadConfig reads configuration from file or environment variables.
@@ -23,6 +119,7 @@ func LoadConfig(path string) (config Config, err error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 
+	setDefaults()
 	viper.AutomaticEnv()
 
 	err = viper.ReadInConfig()
@@ -33,3 +130,47 @@ func LoadConfig(path string) (config Config, err error) {
 	err = viper.Unmarshal(&config)
 	return
 }
+
+// TrustedProxyList returns TrustedProxies as a list, or nil to trust no proxy.
+func (c Config) TrustedProxyList() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// DefaultKeyID identifies JwtSecret within the signing key set.
+const DefaultKeyID = "default"
+
+// SigningKeys returns the id of the key used for signing new tokens and every
+// key accepted when verifying them.
+func (c Config) SigningKeys() (activeKeyID string, keys map[string][]byte) {
+	keys = make(map[string][]byte)
+	if c.JwtSecret != "" {
+		keys[DefaultKeyID] = []byte(c.JwtSecret)
+	}
+
+	var firstKeyID string
+	for _, pair := range strings.Split(c.JwtKeys, ",") {
+		kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || kid == "" || secret == "" {
+			continue
+		}
+		keys[kid] = []byte(secret)
+		if firstKeyID == "" {
+			firstKeyID = kid
+		}
+	}
+
+	activeKeyID = c.JwtActiveKeyID
+	if activeKeyID == "" {
+		activeKeyID = firstKeyID
+	}
+	if activeKeyID == "" {
+		activeKeyID = DefaultKeyID
+	}
+	return activeKeyID, keys
+}
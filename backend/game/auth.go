@@ -1,20 +1,16 @@
 package game
 
 import (
-	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"gorm.io/gorm"
 
 	"panzerstadt/async-multiplayer/config"
@@ -25,13 +21,22 @@ var (
 	oAuthGoogleUrlAPI = "https://www.googleapis.com/oauth2/v2/userinfo"
 )
 
+// InitOAuth registers the identity providers enabled in the configuration.
+// Google is always registered; the others only when configured.
 func InitOAuth(cfg config.Config) {
-	oAuthConf = &oauth2.Config{
-		ClientID:     cfg.GoogleOauthClientID,
-		ClientSecret: cfg.GoogleOauthClientSecret,
-		RedirectURL:  cfg.GoogleOauthRedirectUrl,
-		Scopes:       []string{"email", "profile"},
-		Endpoint:     google.Endpoint,
+	googleProvider := NewGoogleProvider(cfg)
+	googleProvider.UserInfoURL = oAuthGoogleUrlAPI
+	oAuthConf = googleProvider.Config
+	RegisterProvider(googleProvider)
+
+	if cfg.DiscordOauthClientID != "" {
+		RegisterProvider(NewDiscordProvider(cfg))
+	}
+	if cfg.GithubOauthClientID != "" {
+		RegisterProvider(NewGitHubProvider(cfg))
+	}
+	if cfg.SteamReturnUrl != "" {
+		RegisterProvider(NewSteamProvider(cfg))
 	}
 }
 
@@ -46,123 +51,127 @@ func GenerateStateOauthCookie(c *gin.Context, cfg config.Config) string {
 	return state
 }
 
-func GoogleLoginHandler(cfg config.Config) gin.HandlerFunc {
+// ProviderLoginHandler redirects to the login page of the provider named in
+// the :provider path parameter.
+func ProviderLoginHandler(cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		provider, ok := GetProvider(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
+			return
+		}
+
 		state := GenerateStateOauthCookie(c, cfg)
-		url := oAuthConf.AuthCodeURL(state)
-		c.Redirect(http.StatusTemporaryRedirect, url)
+		c.Redirect(http.StatusTemporaryRedirect, provider.LoginURL(state))
+	}
+}
+
+// TokenQueryMiddleware accepts the access token as ?token= for clients that
+// cannot set headers, such as the browser's EventSource. It must run before
+// AuthMiddleware.
+func TokenQueryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
 	}
 }
 
-func AuthMiddleware(cfg config.Config) gin.HandlerFunc {
+// AuthMiddleware validates the access token from the Authorization header, or
+// from the access token cookie when cookie mode is enabled, and rejects tokens
+// whose jti has been revoked.
+func AuthMiddleware(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fmt.Println("AuthMiddleware triggered") // Logging
+		var tokenString string
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		if authHeader != "" {
+			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+			if tokenString == authHeader {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+				return
+			}
+		} else if cookie, err := c.Cookie(accessTokenCookie); cfg.AuthCookieMode && err == nil && cookie != "" {
+			tokenString = cookie
+		} else {
 			fmt.Println("Authorization header is missing") // Logging
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+		claims, err := ParseToken(cfg, tokenString, cfg.JwtAudience)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(cfg.JwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil || claims.ID == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			c.Set("userID", claims["sub"])
-			fmt.Printf("AuthMiddleware: UserID set in context: %v\n", claims["sub"])
-		} else {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		var revoked RevokedToken
+		if err := db.Where("jti = ?", claims.ID).First(&revoked).Error; err == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		} else if err != gorm.ErrRecordNotFound {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to verify token"})
 			return
 		}
 
+		c.Set("claims", claims)
+		c.Set("userID", userID)
+		c.Set("tokenID", claims.ID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		fmt.Printf("AuthMiddleware: UserID set in context: %v\n", userID)
+
 		c.Next()
 	}
 }
 
-func GoogleCallbackHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+// ProviderCallbackHandler completes a login with the provider named in the
+// :provider path parameter and signs the user in.
+func ProviderCallbackHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		state, err := c.Cookie("oauthstate")
-		if err != nil || c.Query("state") != state {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+		provider, ok := GetProvider(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
 			return
 		}
 
-		code := c.Query("code")
-		token, err := oAuthConf.Exchange(context.Background(), code)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "code exchange failed"})
-			return
-		}
-
-		client := oAuthConf.Client(context.Background(), token)
-		response, err := client.Get(oAuthGoogleUrlAPI)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user info"})
+		state, err := c.Cookie("oauthstate")
+		if err != nil || c.Query("state") != state {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
 			return
 		}
-		defer response.Body.Close()
 
-		contents, err := ioutil.ReadAll(response.Body)
+		identity, err := provider.Identify(c.Request.Context(), c.Request.URL.Query())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read user info"})
+			fmt.Printf("Warning: %s login failed: %v\n", provider.Name(), err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to verify login with " + provider.Name()})
 			return
 		}
-		fmt.Printf("Raw Google User Info Response: %s\n", contents)
+		fmt.Printf("Parsed User Info - Provider: %s, ID: %s, Email: %s\n", identity.Provider, identity.Subject, identity.Email)
 
-		var userInfo struct {
-			ID    string `json:"id"`
-			Email string `json:"email"`
+		var user User
+		linkUserID, linking := linkingUserID(c, cfg, state)
+		if linking {
+			user, err = linkIdentity(db, linkUserID, identity)
+		} else {
+			user, err = resolveIdentity(db, identity)
 		}
-		if err := json.Unmarshal(contents, &userInfo); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse user info"})
+		if err == errIdentityInUse {
+			offerMerge(c, db, cfg, linkUserID, identity)
 			return
 		}
-		fmt.Printf("Parsed User Info - ID: %s, Email: %s\n", userInfo.ID, userInfo.Email)
-
-		// Validate essential user info from provider
-		if userInfo.Email == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "email not provided by OAuth provider"})
-			return
-		}
-
-		// Find or create user in DB
-		var user User
-		db.Where(User{Email: userInfo.Email}).FirstOrCreate(&user, User{
-			Email:        userInfo.Email,
-			AuthProvider: "google",
-		})
-
-		// Generate JWT
-		jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub":   user.ID,
-			"email": user.Email,
-			"exp":   time.Now().Add(time.Hour * 24).Unix(),
-		})
-
-		// Sign and get the complete encoded token as a string using the secret
-		tokenString, err := jwtToken.SignedString([]byte(cfg.JwtSecret))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find or create user"})
 			return
 		}
 
-		// Redirect to frontend with token
-		c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("%s?token=%s", cfg.FrontendUrl, tokenString))
+		completeLogin(c, db, cfg, user)
 	}
 }
 
@@ -176,4 +185,9 @@ func GetOAuthGoogleUrlAPI() string {
 
 func SetOAuthGoogleUrlAPI(url string) {
 	oAuthGoogleUrlAPI = url
+	if p, ok := GetProvider("google"); ok {
+		if googleProvider, ok := p.(*OAuth2Provider); ok {
+			googleProvider.UserInfoURL = url
+		}
+	}
 }
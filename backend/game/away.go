@@ -0,0 +1,247 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// maxAwayPeriod limits how far ahead a player can be away.
+const maxAwayPeriod = 90 * 24 * time.Hour
+
+type AwayRequest struct {
+	Until time.Time `json:"until" binding:"required"`
+	// SubstituteUserID is a member of the game who plays the player's turns
+	// while they are away. Without one, their turns are skipped.
+	SubstituteUserID *uuid.UUID `json:"substitute_user_id"`
+}
+
+// turnRecipient returns who should play a seat's turn: its player, or their
+// substitute while they are away. It reports false when the player is away
+// without a substitute. The seat's User must be preloaded.
+func turnRecipient(db *gorm.DB, seat Player) (User, bool) {
+	if !seat.isAway(time.Now()) {
+		return seat.User, true
+	}
+	if seat.AwaySubstituteID == nil {
+		return User{}, false
+	}
+	var substitute User
+	if err := db.First(&substitute, "id = ?", *seat.AwaySubstituteID).Error; err != nil {
+		fmt.Printf("Warning: failed to load substitute %s: %v\n", *seat.AwaySubstituteID, err)
+		return User{}, false
+	}
+	return substitute, true
+}
+
+// coveredSeat returns the seat whose turn it is if userID is the substitute
+// of its away player. In simultaneous games that is any seat they cover which
+// has yet to submit this round.
+func coveredSeat(db *gorm.DB, game Game, userID uuid.UUID) (Player, bool) {
+	if game.Settings.simultaneous() {
+		var seats []Player
+		if err := db.Scopes(seated).Where("game_id = ? AND away_substitute_id = ?", game.ID, userID).
+			Order("turn_order ASC").Find(&seats).Error; err != nil {
+			return Player{}, false
+		}
+		for _, seat := range seats {
+			if done, err := submittedThisRound(db, game, seat); err == nil && !done && seat.isAway(time.Now()) {
+				return seat, true
+			}
+		}
+		return Player{}, false
+	}
+	if game.CurrentTurnID == nil {
+		return Player{}, false
+	}
+	var seat Player
+	if err := db.First(&seat, "id = ?", *game.CurrentTurnID).Error; err != nil {
+		return Player{}, false
+	}
+	if !seat.isAway(time.Now()) || seat.AwaySubstituteID == nil || *seat.AwaySubstituteID != userID {
+		return Player{}, false
+	}
+	return seat, true
+}
+
+// loadOwnSeat loads the current user's seat in the game. Spectators have no
+// turns to be away from.
+func loadOwnSeat(c *gin.Context, db *gorm.DB, game Game, userID uuid.UUID) (player Player, ok bool) {
+	if err := db.Preload("User").Where("game_id = ? AND user_id = ?", game.ID, userID).First(&player).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this game"})
+		return
+	}
+	if player.Role == RoleSpectator {
+		c.JSON(http.StatusConflict, gin.H{"error": "spectators do not take turns"})
+		return player, false
+	}
+	return player, true
+}
+
+// SetAwayHandler starts or changes the current player's away period. If they
+// hold the turn, it passes on at once: to their substitute, or else to the
+// next player who is not away.
+func SetAwayHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		player, ok := loadOwnSeat(c, db, game, userID)
+		if !ok {
+			return
+		}
+
+		var req AwayRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be an RFC 3339 time"})
+			return
+		}
+		now := time.Now()
+		if !req.Until.After(now) || req.Until.Sub(now) > maxAwayPeriod {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("until must be within the next %d days", int(maxAwayPeriod.Hours()/24))})
+			return
+		}
+		if req.SubstituteUserID != nil {
+			if *req.SubstituteUserID == userID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot substitute for yourself"})
+				return
+			}
+			// Spectators may stand in: they have no seat of their own to play.
+			var substitute Player
+			if err := db.Where("game_id = ? AND user_id = ?", game.ID, *req.SubstituteUserID).First(&substitute).Error; err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "substitute must be a member of this game"})
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if substitute.isAway(now) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "substitute is away"})
+				return
+			}
+		}
+
+		player.AwayUntil = &req.Until
+		player.AwaySubstituteID = req.SubstituteUserID
+		if err := db.Model(&player).Select("away_until", "away_substitute_id").Updates(&player).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set away period"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, userID, "player_away", map[string]interface{}{
+			"game_id":            game.ID.String(),
+			"player_id":          player.ID.String(),
+			"user_id":            userID.String(),
+			"until":              req.Until,
+			"substitute_user_id": req.SubstituteUserID,
+		})
+
+		if game.Status == GameStatusActive && game.CurrentTurnID != nil && *game.CurrentTurnID == player.ID {
+			passTurnFromAway(db, sseManager, notifier, game, player)
+		}
+		// The round may have been waiting only for them.
+		completeRound(db, sseManager, notifier, game, userID)
+
+		c.JSON(http.StatusOK, gin.H{"away_until": player.AwayUntil, "away_substitute_id": player.AwaySubstituteID})
+	}
+}
+
+// passTurnFromAway tells whoever now plays the turn of a player who just went
+// away: their substitute, or the next player after the turn is skipped.
+func passTurnFromAway(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, game Game, away Player) {
+	seat := away
+	if away.AwaySubstituteID == nil {
+		nextTurnID, err := advanceTurn(db, game.ID)
+		if err != nil {
+			fmt.Printf("Warning: failed to advance turn for game %s: %v\n", game.ID, err)
+			return
+		}
+		publishEvent(db, sseManager, game.ID, away.UserID, "turn_changed", turnChangedEvent(db, game.ID, nextTurnID))
+		if err := db.Preload("User").First(&seat, "id = ?", nextTurnID).Error; err != nil {
+			fmt.Printf("Warning: failed to get next player for notification: %v\n", err)
+			return
+		}
+	}
+
+	recipient, ok := turnRecipient(db, seat)
+	if !ok || recipient.Email == "" || recipient.ID == away.UserID {
+		return
+	}
+	subject := fmt.Sprintf("It's your turn in %s", game.Name)
+	body := fmt.Sprintf("%s is away until %s, so it's now your turn in %s.",
+		displayName(away.User), localDate(recipient, *away.AwayUntil), game.Name)
+	if recipient.ID != seat.UserID {
+		body = fmt.Sprintf("%s is away until %s and asked you to cover their turns in %s. It's their turn now.",
+			displayName(away.User), localDate(recipient, *away.AwayUntil), game.Name)
+	}
+	if err := notifier.Notify(recipient.Email, subject, body); err != nil {
+		fmt.Printf("Warning: failed to send email to %s: %v\n", recipient.Email, err)
+	}
+}
+
+// coveredBy lists the seats of a game whose away player userID covers for.
+func coveredBy(db *gorm.DB, gameID, userID uuid.UUID) ([]Player, error) {
+	var seats []Player
+	err := db.Preload("User").Where("game_id = ? AND away_substitute_id = ?", gameID, userID).Find(&seats).Error
+	return seats, err
+}
+
+// passUncoveredTurn passes the turn on if it is held by one of seats, which
+// have just lost their substitute, as for anyone away without one.
+func passUncoveredTurn(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, gameID uuid.UUID, seats []Player) {
+	if len(seats) == 0 {
+		return
+	}
+	var game Game
+	if err := db.First(&game, "id = ?", gameID).Error; err != nil {
+		fmt.Printf("Warning: failed to load game %s: %v\n", gameID, err)
+		return
+	}
+	if game.Status != GameStatusActive || game.CurrentTurnID == nil {
+		return
+	}
+	now := time.Now()
+	for _, seat := range seats {
+		if seat.ID == *game.CurrentTurnID && seat.isAway(now) {
+			seat.AwaySubstituteID = nil
+			passTurnFromAway(db, sseManager, notifier, game, seat)
+			return
+		}
+	}
+}
+
+// ClearAwayHandler ends the current player's away period early.
+func ClearAwayHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		player, ok := loadOwnSeat(c, db, game, userID)
+		if !ok {
+			return
+		}
+
+		if err := db.Model(&player).Updates(map[string]interface{}{
+			"away_until":         nil,
+			"away_substitute_id": nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear away period"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, userID, "player_back", map[string]interface{}{
+			"game_id":   game.ID.String(),
+			"player_id": player.ID.String(),
+			"user_id":   userID.String(),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Welcome back"})
+	}
+}
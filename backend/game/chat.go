@@ -0,0 +1,447 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+const (
+	maxMessageLength    = 2000
+	defaultMessagesPage = 50
+	maxMessagesPage     = 100
+)
+
+type MessageRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+type ChatMessagesPage struct {
+	Messages []ChatMessageResponse `json:"messages"`
+	// NextCursor fetches older messages when passed as ?before=; it is empty
+	// on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cleanText trims text and checks that it is at most max characters of
+// valid UTF-8 without control characters other than newlines and tabs.
+func cleanText(text string, max int) (string, error) {
+	text = strings.TrimSpace(text)
+	if !utf8.ValidString(text) {
+		return "", fmt.Errorf("must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(text) > max {
+		return "", fmt.Errorf("must be at most %d characters", max)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return "", fmt.Errorf("contains control characters")
+		}
+	}
+	return text, nil
+}
+
+// validateMessage trims a chat message and checks its length and content.
+func validateMessage(message string) (string, error) {
+	message, err := cleanText(message, maxMessageLength)
+	if err != nil {
+		return "", fmt.Errorf("message %v", err)
+	}
+	if message == "" {
+		return "", fmt.Errorf("message is required")
+	}
+	return message, nil
+}
+
+// loadGameMessage loads the :messageId of game with its author and reactions.
+func loadGameMessage(c *gin.Context, db *gorm.DB, game Game) (message ChatMessage, ok bool) {
+	messageID, err := uuid.Parse(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if err := db.Preload("Author").Preload("Reactions", orderByCreatedAt).
+		Where("id = ? AND game_id = ?", messageID, game.ID).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	return message, true
+}
+
+// loadOwnMessage loads the :messageId of game and checks that userID wrote it.
+func loadOwnMessage(c *gin.Context, db *gorm.DB, game Game, userID uuid.UUID) (message ChatMessage, ok bool) {
+	message, ok = loadGameMessage(c, db, game)
+	if !ok {
+		return
+	}
+	if message.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you can only change your own messages"})
+		return message, false
+	}
+	return message, true
+}
+
+func orderByCreatedAt(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at ASC")
+}
+
+// isHandleRune reports whether r can be part of an @mention.
+func isHandleRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// mentionHandles returns the lower-cased names a user can be mentioned by:
+// their display name without spaces and the local part of their email.
+func mentionHandles(user User) []string {
+	handles := []string{strings.ToLower(strings.Join(strings.Fields(displayName(user)), ""))}
+	if i := strings.Index(user.Email, "@"); i > 0 {
+		handles = append(handles, strings.ToLower(user.Email[:i]))
+	}
+	return handles
+}
+
+// containsMention reports whether text mentions @handle as a whole word.
+func containsMention(text, handle string) bool {
+	if handle == "" {
+		return false
+	}
+	target := "@" + handle
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], target)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(target)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isHandleRune(before)) && (end == len(text) || !isHandleRune(after)) {
+			return true
+		}
+		offset = end
+	}
+}
+
+// findMentions returns the members of a game, other than the author, that
+// body mentions with @name.
+func findMentions(db *gorm.DB, gameID, authorID uuid.UUID, body string) ([]Player, error) {
+	if !strings.Contains(body, "@") {
+		return nil, nil
+	}
+	var members []Player
+	if err := db.Preload("User").Where("game_id = ? AND user_id <> ?", gameID, authorID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	text := strings.ToLower(body)
+	var mentioned []Player
+	for _, member := range members {
+		for _, handle := range mentionHandles(member.User) {
+			if containsMention(text, handle) {
+				mentioned = append(mentioned, member)
+				break
+			}
+		}
+	}
+	return mentioned, nil
+}
+
+func mentionedUserIDs(players []Player) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(players))
+	for _, p := range players {
+		ids = append(ids, p.UserID)
+	}
+	return ids
+}
+
+// notifyMentions emails each mentioned player the message they were
+// mentioned in.
+func notifyMentions(notifier Notifier, game Game, message ChatMessage, mentioned []Player) {
+	author := displayName(message.Author)
+	subject := fmt.Sprintf("%s mentioned you in %s", author, game.Name)
+	for _, p := range mentioned {
+		if p.User.Email == "" || p.isAway(time.Now()) {
+			continue
+		}
+		body := fmt.Sprintf("%s wrote in %s:\n\n%s", author, game.Name, message.Body)
+		if err := notifier.Notify(p.User.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", p.User.Email, err)
+		}
+	}
+}
+
+// MessageHandler posts a chat message to a game and pushes it to everyone
+// watching as a chat_message event. Players mentioned with @name are also
+// emailed.
+func MessageHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+
+		var req MessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message is required"})
+			return
+		}
+		body, err := validateMessage(req.Message)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		mentioned, err := findMentions(db, game.ID, userID, body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve mentions"})
+			return
+		}
+
+		message := ChatMessage{GameID: game.ID, AuthorID: userID, Body: body, Mentions: mentionedUserIDs(mentioned)}
+		if err := db.Create(&message).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save message"})
+			return
+		}
+		if err := db.First(&message.Author, "id = ?", userID).Error; err != nil {
+			fmt.Printf("Warning: failed to load author of message %s: %v\n", message.ID, err)
+		}
+		notifyMentions(notifier, game, message, mentioned)
+
+		// The activity log keeps only the message ID, so deleting a message
+		// removes its text everywhere.
+		recordEvent(db, game.ID, userID, "message_posted", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"message_id": message.ID.String(),
+		})
+
+		response := newChatMessageResponse(message)
+		sendToMembers(db, sseManager, game.ID, "", "chat_message", response)
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// GetMessagesHandler returns a game's chat, newest first, a page at a time.
+// Pass the previous page's next_cursor as ?before= to continue.
+func GetMessagesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, _, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+
+		limit, ok := parsePageLimit(c, defaultMessagesPage, maxMessagesPage)
+		if !ok {
+			return
+		}
+
+		query := db.Preload("Author").Preload("Reactions", orderByCreatedAt).Where("game_id = ?", game.ID)
+		if raw := c.Query("before"); raw != "" {
+			cursorID, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			// Deleted messages still anchor a cursor taken before they went.
+			var cursor ChatMessage
+			if err := db.Unscoped().Where("id = ? AND game_id = ?", cursorID, game.ID).First(&cursor).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+
+		var messages []ChatMessage
+		if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+			return
+		}
+
+		page := ChatMessagesPage{Messages: make([]ChatMessageResponse, 0, limit)}
+		if len(messages) > limit {
+			messages = messages[:limit]
+			page.NextCursor = messages[limit-1].ID.String()
+		}
+		for _, m := range messages {
+			page.Messages = append(page.Messages, newChatMessageResponse(m))
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// EditMessageHandler lets the author change a message they wrote. Players
+// newly mentioned by the edit are emailed.
+func EditMessageHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		message, ok := loadOwnMessage(c, db, game, userID)
+		if !ok {
+			return
+		}
+
+		var req MessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message is required"})
+			return
+		}
+		body, err := validateMessage(req.Message)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		mentioned, err := findMentions(db, game.ID, userID, body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve mentions"})
+			return
+		}
+		alreadyMentioned := make(map[uuid.UUID]bool, len(message.Mentions))
+		for _, id := range message.Mentions {
+			alreadyMentioned[id] = true
+		}
+		var newlyMentioned []Player
+		for _, p := range mentioned {
+			if !alreadyMentioned[p.UserID] {
+				newlyMentioned = append(newlyMentioned, p)
+			}
+		}
+
+		now := time.Now()
+		message.Body = body
+		message.Mentions = mentionedUserIDs(mentioned)
+		message.EditedAt = &now
+		if err := db.Model(&message).Select("body", "mentions", "edited_at").Updates(&message).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+			return
+		}
+		notifyMentions(notifier, game, message, newlyMentioned)
+
+		response := newChatMessageResponse(message)
+		sendToMembers(db, sseManager, game.ID, "", "chat_message_edited", response)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteMessageHandler lets the author remove a message they wrote.
+func DeleteMessageHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		message, ok := loadOwnMessage(c, db, game, userID)
+		if !ok {
+			return
+		}
+
+		if err := db.Delete(&message).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+
+		recordEvent(db, game.ID, userID, "message_deleted", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"message_id": message.ID.String(),
+		})
+		sendToMembers(db, sseManager, game.ID, "", "chat_message_deleted", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"message_id": message.ID.String(),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+	}
+}
+
+type MarkReadRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
+}
+
+// MarkMessagesReadHandler moves the current player's read cursor up to a
+// message. The cursor never moves backwards, so marking an older message
+// read is a no-op.
+func MarkMessagesReadHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+
+		var req MarkReadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message_id is required"})
+			return
+		}
+
+		var player Player
+		if err := db.Where("game_id = ? AND user_id = ?", game.ID, userID).First(&player).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this game"})
+			return
+		}
+		var message ChatMessage
+		if err := db.Where("id = ? AND game_id = ?", req.MessageID, game.ID).First(&message).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+
+		if player.LastReadAt == nil || message.CreatedAt.After(*player.LastReadAt) {
+			if err := db.Model(&player).Updates(map[string]interface{}{
+				"last_read_message_id": message.ID,
+				"last_read_at":         message.CreatedAt,
+			}).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages read"})
+				return
+			}
+			player.LastReadMessageID = &message.ID
+			player.LastReadAt = &message.CreatedAt
+
+			sendToMembers(db, sseManager, game.ID, "", "chat_read", map[string]interface{}{
+				"game_id":    game.ID.String(),
+				"player_id":  player.ID.String(),
+				"user_id":    userID.String(),
+				"message_id": message.ID.String(),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"last_read_message_id": player.LastReadMessageID,
+			"last_read_at":         player.LastReadAt,
+		})
+	}
+}
+
+// unreadCounts returns how many messages from others the user has not read
+// yet in each of the given games.
+func unreadCounts(db *gorm.DB, userID uuid.UUID, gameIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	counts := make(map[uuid.UUID]int64, len(gameIDs))
+	if len(gameIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		GameID uuid.UUID
+		Unread int64
+	}
+	err := db.Model(&ChatMessage{}).
+		Select("chat_messages.game_id AS game_id, COUNT(*) AS unread").
+		Joins("JOIN players ON players.game_id = chat_messages.game_id AND players.user_id = ?", userID).
+		Where("chat_messages.game_id IN ? AND chat_messages.author_id <> ?", gameIDs, userID).
+		Where("players.last_read_at IS NULL OR chat_messages.created_at > players.last_read_at").
+		Group("chat_messages.game_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.GameID] = row.Unread
+	}
+	return counts, nil
+}
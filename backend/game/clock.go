@@ -0,0 +1,225 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// Penalties for running out of time in a game with a chess clock.
+const (
+	ClockPenaltyNotify = "notify" // email the players
+	ClockPenaltySkip   = "skip"   // pass the turn to the next player
+	ClockPenaltyPause  = "pause"  // pause the game
+)
+
+// switchClock charges the running turn to the player holding it, then
+// restarts the clock for the next turn, or stops it if running is false.
+// Time is tracked for every game so that a clock can be switched on later.
+func switchClock(tx *gorm.DB, game *Game, running bool) error {
+	now := time.Now()
+	if game.CurrentTurnID != nil && game.TurnStartedAt != nil {
+		elapsed := int64(now.Sub(*game.TurnStartedAt).Seconds())
+		if err := tx.Model(&Player{}).Where("id = ?", *game.CurrentTurnID).
+			Update("time_used_seconds", gorm.Expr("time_used_seconds + ?", elapsed)).Error; err != nil {
+			return err
+		}
+	}
+
+	var startedAt *time.Time
+	if running {
+		startedAt = &now
+	}
+	if err := tx.Model(&Game{}).Where("id = ?", game.ID).Update("turn_started_at", startedAt).Error; err != nil {
+		return err
+	}
+	game.TurnStartedAt = startedAt
+	return nil
+}
+
+// timeRemaining is what is left of a player's time bank at now.
+func timeRemaining(game Game, player Player, now time.Time) time.Duration {
+	remaining := time.Duration(game.Settings.TimeBankHours)*time.Hour - time.Duration(player.TimeUsedSeconds)*time.Second
+	if game.TurnStartedAt != nil && game.CurrentTurnID != nil && *game.CurrentTurnID == player.ID {
+		remaining -= now.Sub(*game.TurnStartedAt)
+	}
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// turnChangedEvent builds the turn_changed payload. Games with a chess clock
+// also get the seconds left in each seat's time bank.
+func turnChangedEvent(db *gorm.DB, gameID, playerID uuid.UUID) map[string]interface{} {
+	event := map[string]interface{}{
+		"game_id":   gameID.String(),
+		"player_id": playerID.String(),
+	}
+
+	var game Game
+	if err := db.First(&game, "id = ?", gameID).Error; err != nil || game.Settings.TimeBankHours == 0 {
+		return event
+	}
+	var players []Player
+	if err := db.Scopes(seated).Where("game_id = ?", gameID).Find(&players).Error; err != nil {
+		fmt.Printf("Warning: failed to load clocks for game %s: %v\n", gameID, err)
+		return event
+	}
+	now := time.Now()
+	clocks := make(map[string]int64, len(players))
+	for _, p := range players {
+		clocks[p.ID.String()] = int64(timeRemaining(game, p, now).Seconds())
+	}
+	event["time_remaining_seconds"] = clocks
+	return event
+}
+
+// EnforceTimeBanks applies each game's penalty to turn holders whose time
+// bank has run out. A player is penalised once; after that their turns are
+// no longer timed. It returns how many penalties were applied.
+func EnforceTimeBanks(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) (int, error) {
+	var games []Game
+	if err := db.Where("status = ? AND settings_time_bank_hours > 0 AND current_turn_id IS NOT NULL AND turn_started_at IS NOT NULL",
+		GameStatusActive).Find(&games).Error; err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	penalised := 0
+	for _, game := range games {
+		var holder Player
+		if err := db.Preload("User").First(&holder, "id = ?", *game.CurrentTurnID).Error; err != nil {
+			fmt.Printf("Warning: failed to load turn holder of game %s: %v\n", game.ID, err)
+			continue
+		}
+		if holder.OutOfTimeAt != nil || timeRemaining(game, holder, now) > 0 {
+			continue
+		}
+		nextTurnID, applied, err := claimClockPenalty(db, &game, holder, now)
+		if err != nil {
+			fmt.Printf("Warning: failed to apply the clock penalty to %s: %v\n", holder.ID, err)
+			continue
+		}
+		if !applied {
+			continue
+		}
+		announceClockPenalty(db, sseManager, notifier, game, holder, nextTurnID)
+		penalised++
+	}
+	return penalised, nil
+}
+
+// clockPenalty is the game's time_bank_penalty, defaulting to notify.
+func clockPenalty(game Game) string {
+	if game.Settings.TimeBankPenalty == "" {
+		return ClockPenaltyNotify
+	}
+	return game.Settings.TimeBankPenalty
+}
+
+// claimClockPenalty marks holder out of time and carries out the game's
+// penalty, provided they still hold the turn of the active game. If a save
+// moved the turn on in the meantime nothing happens and applied is false.
+// For the skip penalty it returns the seat that now holds the turn.
+func claimClockPenalty(db *gorm.DB, game *Game, holder Player, now time.Time) (nextTurnID uuid.UUID, applied bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Game{}).Where("id = ? AND current_turn_id = ? AND status = ?", game.ID, holder.ID, GameStatusActive).
+			UpdateColumn("current_turn_id", holder.ID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Model(&holder).Update("out_of_time_at", now).Error; err != nil {
+			return err
+		}
+
+		switch clockPenalty(*game) {
+		case ClockPenaltySkip:
+			next, err := advanceTurn(tx, game.ID)
+			if err != nil {
+				return err
+			}
+			nextTurnID = next
+		case ClockPenaltyPause:
+			if err := tx.Model(&Game{}).Where("id = ?", game.ID).Update("status", GameStatusPaused).Error; err != nil {
+				return err
+			}
+			if err := switchClock(tx, game, false); err != nil {
+				return err
+			}
+		}
+		applied = true
+		return nil
+	})
+	return nextTurnID, applied && err == nil, err
+}
+
+// announceClockPenalty tells everyone that a player ran out of time and what
+// claimClockPenalty did about it.
+func announceClockPenalty(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, game Game, holder Player, nextTurnID uuid.UUID) {
+	penalty := clockPenalty(game)
+	publishEvent(db, sseManager, game.ID, uuid.Nil, "time_bank_expired", map[string]interface{}{
+		"game_id":   game.ID.String(),
+		"player_id": holder.ID.String(),
+		"user_id":   holder.UserID.String(),
+		"penalty":   penalty,
+	})
+
+	name := displayName(holder.User)
+	body := fmt.Sprintf("%s has used up their time bank in %s.", name, game.Name)
+	switch penalty {
+	case ClockPenaltySkip:
+		publishEvent(db, sseManager, game.ID, uuid.Nil, "turn_changed", turnChangedEvent(db, game.ID, nextTurnID))
+		body += " Their turn has been skipped."
+	case ClockPenaltyPause:
+		previous := game.Status
+		game.Status = GameStatusPaused
+		// The status change email goes to everyone already.
+		notifyStatusChange(db, sseManager, notifier, game, previous, uuid.Nil)
+		return
+	}
+
+	var players []Player
+	if err := db.Preload("User").Where("game_id = ?", game.ID).Find(&players).Error; err != nil {
+		fmt.Printf("Warning: failed to get players for notification: %v\n", err)
+		return
+	}
+	subject := fmt.Sprintf("%s ran out of time in %s", name, game.Name)
+	for _, p := range players {
+		if p.User.Email == "" || p.isAway(time.Now()) {
+			continue
+		}
+		if err := notifier.Notify(p.User.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", p.User.Email, err)
+		}
+	}
+}
+
+// StartClockJob runs EnforceTimeBanks every interval until stop is called.
+// An interval of zero or less disables the job.
+func StartClockJob(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		fmt.Printf("Warning: chess clock job disabled, CLOCK_CHECK_INTERVAL is %v\n", interval)
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := EnforceTimeBanks(db, sseManager, notifier); err != nil {
+					fmt.Printf("Warning: chess clock check failed: %v\n", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
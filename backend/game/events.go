@@ -0,0 +1,195 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+const (
+	defaultEventsPage = 50
+	maxEventsPage     = 100
+	// maxReplayEvents caps what a reconnecting SSE client is sent.
+	maxReplayEvents = 100
+)
+
+type GameEventsPage struct {
+	Events []GameEventResponse `json:"events"`
+	// NextCursor fetches older events when passed as ?before=; it is empty
+	// on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parsePageLimit reads ?limit=, answering 400 if it is outside 1..max.
+func parsePageLimit(c *gin.Context, defaultLimit, max int) (int, bool) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return defaultLimit, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", max)})
+		return 0, false
+	}
+	return n, true
+}
+
+// actorOf returns the user making the request, or uuid.Nil if there is none.
+func actorOf(c *gin.Context) uuid.UUID {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
+
+// recordEvent appends an entry to a game's activity log. A failure is logged
+// but never fails the action being recorded.
+func recordEvent(db *gorm.DB, gameID, actorID uuid.UUID, eventType string, data interface{}) (GameEvent, bool) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode %s event for game %s: %v\n", eventType, gameID, err)
+		return GameEvent{}, false
+	}
+	event := GameEvent{GameID: gameID, Type: eventType, Data: raw}
+	if actorID != uuid.Nil {
+		event.ActorID = &actorID
+	}
+	if err := db.Create(&event).Error; err != nil {
+		fmt.Printf("Warning: failed to record %s event for game %s: %v\n", eventType, gameID, err)
+		return GameEvent{}, false
+	}
+	return event, true
+}
+
+// memberUserIDs lists the users who belong to a game, spectators included.
+func memberUserIDs(db *gorm.DB, gameID uuid.UUID) []string {
+	var userIDs []uuid.UUID
+	if err := db.Model(&Player{}).Where("game_id = ?", gameID).Pluck("user_id", &userIDs).Error; err != nil {
+		fmt.Printf("Warning: failed to load members of game %s: %v\n", gameID, err)
+		return nil
+	}
+	ids := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		ids = append(ids, id.String())
+	}
+	return ids
+}
+
+// sendToMembers sends an SSE event to the members of a game only. Pass an
+// empty id for events that are not in the activity log.
+func sendToMembers(db *gorm.DB, sseManager sse.Broadcaster, gameID uuid.UUID, id, eventType string, data interface{}) {
+	sseManager.SendEvent(memberUserIDs(db, gameID), id, eventType, data)
+}
+
+// publishEvent records a game event and sends it to the game's members under
+// the event's ID, so that SSE clients that reconnect can be sent what they
+// missed.
+func publishEvent(db *gorm.DB, sseManager sse.Broadcaster, gameID, actorID uuid.UUID, eventType string, data interface{}) {
+	event, _ := recordEvent(db, gameID, actorID, eventType, data)
+	id := ""
+	if event.ID != uuid.Nil {
+		id = event.ID.String()
+	}
+	sendToMembers(db, sseManager, gameID, id, eventType, data)
+}
+
+// GetGameEventsHandler returns a game's activity log, newest first, a page at
+// a time. Pass the previous page's next_cursor as ?before= to continue.
+func GetGameEventsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, _, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		limit, ok := parsePageLimit(c, defaultEventsPage, maxEventsPage)
+		if !ok {
+			return
+		}
+
+		query := db.Preload("Actor").Where("game_id = ?", game.ID)
+		if raw := c.Query("before"); raw != "" {
+			cursorID, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			var cursor GameEvent
+			if err := db.Where("id = ? AND game_id = ?", cursorID, game.ID).First(&cursor).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+
+		var events []GameEvent
+		if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+			return
+		}
+
+		page := GameEventsPage{Events: make([]GameEventResponse, 0, limit)}
+		if len(events) > limit {
+			events = events[:limit]
+			page.NextCursor = events[limit-1].ID.String()
+		}
+		for _, e := range events {
+			page.Events = append(page.Events, newGameEventResponse(e))
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// ReplayEvents returns the replay function for sse.ServeSSE: the events
+// recorded after lastEventID in the games userID is a member of, oldest
+// first. Events of deleted games are left out.
+func ReplayEvents(db *gorm.DB, userID uuid.UUID) func(lastEventID string) []sse.Event {
+	return func(lastEventID string) []sse.Event {
+		cursorID, err := uuid.Parse(lastEventID)
+		if err != nil {
+			return nil
+		}
+		memberOf := db.Model(&Player{}).Select("players.game_id").
+			Joins("JOIN games ON games.id = players.game_id AND games.deleted_at IS NULL").
+			Where("players.user_id = ?", userID)
+
+		var cursor GameEvent
+		if err := db.Where("id = ? AND game_id IN (?)", cursorID, memberOf).First(&cursor).Error; err != nil {
+			return nil
+		}
+
+		var events []GameEvent
+		if err := db.Where("game_id IN (?)", memberOf).
+			Where("created_at > ? OR (created_at = ? AND id > ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID).
+			Order("created_at ASC, id ASC").Limit(maxReplayEvents).Find(&events).Error; err != nil {
+			fmt.Printf("Warning: failed to load events to replay: %v\n", err)
+			return nil
+		}
+
+		replay := make([]sse.Event, 0, len(events))
+		for _, e := range events {
+			replay = append(replay, sse.Event{ID: e.ID.String(), Type: e.Type, Data: e.Data})
+		}
+		return replay
+	}
+}
+
+// NotificationsHandler streams the current user's game events over SSE,
+// replaying what they missed when they reconnect.
+func NotificationsHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+		sse.ServeSSE(sseManager, c, userID.String(), ReplayEvents(db, userID))
+	}
+}
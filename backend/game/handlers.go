@@ -14,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"panzerstadt/async-multiplayer/config"
 	"panzerstadt/async-multiplayer/sse"
 )
 
@@ -23,16 +24,11 @@ func getUserIDFromContext(c *gin.Context) (uuid.UUID, error) {
 		return uuid.Nil, fmt.Errorf("user not authenticated")
 	}
 
-	userIDStr, ok := userIDAny.(string)
+	userID, ok := userIDAny.(uuid.UUID)
 	if !ok {
 		return uuid.Nil, fmt.Errorf("invalid user ID format in context")
 	}
 
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID string: %w", err)
-	}
-
 	return userID, nil
 }
 
@@ -61,27 +57,6 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 	}
 }
 
-// Rate limiting middleware
-func RateLimitMiddleware(maxRequests int, duration time.Duration) gin.HandlerFunc {
-	var rateLimiter = make(chan time.Time, maxRequests)
-	go func() {
-		ticker := time.NewTicker(duration / time.Duration(maxRequests))
-		defer ticker.Stop()
-		for t := range ticker.C {
-			rateLimiter <- t
-		}
-	}()
-
-	return func(c *gin.Context) {
-		select {
-		case <-rateLimiter:
-			c.Next()
-		default:
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
-		}
-	}
-}
-
 // Determine MIME type of file buffer by content with a default assumption
 func detectMimeType(file io.Reader) (string, error) {
 	// Read up to 512 bytes to detect mime type
@@ -164,6 +139,29 @@ func GetLatestSaveHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// GetSavesHandler lists a game's saves, newest first, with their notes.
+func GetSavesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, _, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+
+		var saves []Save
+		if err := db.Preload("Uploader").Where("game_id = ?", game.ID).
+			Order("created_at DESC").Find(&saves).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve saves"})
+			return
+		}
+
+		response := make([]SaveResponse, 0, len(saves))
+		for _, save := range saves {
+			response = append(response, newSaveResponse(save))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
 // Security utility functions
 func sanitizeFilename(filename string) string {
 	// Remove path separators and dangerous characters
@@ -210,63 +208,71 @@ func isPathSafe(filePath, baseDir string) bool {
 	return strings.HasPrefix(absFilePath, absBaseDir)
 }
 
-// advanceTurn handles turn management: mark current turn complete and assign next player
-func advanceTurn(db *gorm.DB, gameID uuid.UUID) error {
-	// Get all players in the game, ordered by turn order
+// advanceTurn handles turn management: mark current turn complete and assign next player.
+// It returns the player whose turn it now is.
+func advanceTurn(db *gorm.DB, gameID uuid.UUID) (uuid.UUID, error) {
+	// Get all players in the rotation, ordered by turn order
 	var players []Player
-	if err := db.Where("game_id = ?", gameID).Order("turn_order ASC").Find(&players).Error; err != nil {
-		return fmt.Errorf("failed to get players: %w", err)
+	if err := db.Scopes(seated).Where("game_id = ?", gameID).Order("turn_order ASC").Find(&players).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get players: %w", err)
 	}
 
 	if len(players) == 0 {
-		return fmt.Errorf("no players found for game")
+		return uuid.Nil, fmt.Errorf("no players found for game")
 	}
 
 	// Get current game state
 	var game Game
 	if err := db.First(&game, "id = ?", gameID).Error; err != nil {
-		return fmt.Errorf("failed to get game: %w", err)
+		return uuid.Nil, fmt.Errorf("failed to get game: %w", err)
 	}
 
 	// Find next player in turn order
-	var nextPlayerID *uuid.UUID
-	if game.CurrentTurnID == nil {
-		// First turn, assign to first player
-		nextPlayerID = &players[0].ID
-	} else {
-		// Find current player index
-		currentPlayerIndex := -1
+	start := 0
+	if game.CurrentTurnID != nil {
+		// Current player not found means starting over from the first player
 		for i, player := range players {
 			if player.ID == *game.CurrentTurnID {
-				currentPlayerIndex = i
+				start = i + 1
 				break
 			}
 		}
+	}
 
-		if currentPlayerIndex == -1 {
-			// Current player not found, reset to first player
-			nextPlayerID = &players[0].ID
-		} else {
-			// Move to next player (wrap around if at end)
-			nextIndex := (currentPlayerIndex + 1) % len(players)
-			nextPlayerID = &players[nextIndex].ID
+	// Skip players who are away without a substitute, unless everyone is
+	now := time.Now()
+	next := players[start%len(players)]
+	for i := 0; i < len(players); i++ {
+		candidate := players[(start+i)%len(players)]
+		if !candidate.isAway(now) || candidate.AwaySubstituteID != nil {
+			next = candidate
+			break
 		}
 	}
+	nextPlayerID := &next.ID
+
+	// Charge the finished turn to its player and start the clock for the next
+	if err := switchClock(db, &game, game.Status == GameStatusActive); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to update clock: %w", err)
+	}
 
 	// Update game with next player's turn
 	if err := db.Model(&game).Update("current_turn_id", nextPlayerID).Error; err != nil {
-		return fmt.Errorf("failed to update current turn: %w", err)
+		return uuid.Nil, fmt.Errorf("failed to update current turn: %w", err)
 	}
 
-	return nil
+	return *nextPlayerID, nil
 }
 
 type CreateGameRequest struct {
-	Name    string   `json:"name" binding:"required"`
-	Players []string `json:"players"`
+	Name       string       `json:"name" binding:"required"`
+	Players    []string     `json:"players"`
+	JoinPolicy string       `json:"join_policy"`
+	MaxPlayers int          `json:"max_players"`
+	Settings   GameSettings `json:"settings"`
 }
 
-func CreateGameHandler(db *gorm.DB) gin.HandlerFunc {
+func CreateGameHandler(db *gorm.DB, cfg config.Config, notifier Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		creatorID, err := getUserIDFromContext(c)
 		if err != nil {
@@ -283,35 +289,29 @@ func CreateGameHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 			return
 		}
-
-		// Check if game name already exists
-		var existingGame Game
-		if err := db.Where("name = ?", req.Name).First(&existingGame).Error; err == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "A game with this name already exists."})
+		if req.MaxPlayers < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_players cannot be negative"})
 			return
 		}
 
-		// Create new game
-		game := Game{
-			Name:      req.Name,
-			CreatorID: creatorID,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		if err := validateSettings(req.Settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		if err := db.Create(&game).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create game"})
+		if req.JoinPolicy == "" {
+			req.JoinPolicy = JoinPolicyOpen
+		}
+		if !validJoinPolicy(req.JoinPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "join_policy must be \"open\", \"invite_only\" or \"approval\""})
 			return
 		}
 
-		// Add creator as a player
-		player := Player{
-			UserID:    creatorID,
-			GameID:    game.ID,
-			TurnOrder: 0,
-		}
-		if err := db.Create(&player).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add creator as player"})
+		// Check if game name already exists, including deleted games that can
+		// still be restored
+		var existingGame Game
+		if err := db.Unscoped().Where("name = ?", req.Name).First(&existingGame).Error; err == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A game with this name already exists."})
 			return
 		}
 
@@ -322,63 +322,46 @@ func CreateGameHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Add invited players
-		for _, playerEmail := range req.Players {
-			if playerEmail == creator.Email { // Skip creator if already added
-				continue
-			}
-			var invitedUser User
-			if err := db.Where("email = ?", playerEmail).First(&invitedUser).Error; err != nil {
-				if err == gorm.ErrRecordNotFound {
-					// User not found, create a new one
-					invitedUser = User{
-						Email:        playerEmail,
-						AuthProvider: "email",
-						// ID will be set automatically by BeforeCreate hook
-					}
-					if err := db.Create(&invitedUser).Error; err != nil {
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invited user"})
-						return
-					}
-				} else {
-					// Other database error
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find invited user"})
-					return
-				}
-			}
-
-			// Check if player already exists in the game
-			var existingPlayer Player
-			if err := db.Where("user_id = ? AND game_id = ?", invitedUser.ID, game.ID).First(&existingPlayer).Error; err == nil {
-				continue // Player already in game
-			} else if err != gorm.ErrRecordNotFound {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for existing player"})
-				return
-			}
-
-			// Get next turn order
-			var maxTurnOrder int
-			if err := db.Model(&Player{}).Where("game_id = ?", game.ID).Select("COALESCE(MAX(turn_order), -1)").Scan(&maxTurnOrder).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to determine turn order"})
-				return
-			}
+		game := Game{
+			Name:       req.Name,
+			CreatorID:  creatorID,
+			JoinPolicy: req.JoinPolicy,
+			MaxPlayers: req.MaxPlayers,
+			Settings:   req.Settings,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
 
-			newPlayer := Player{
-				UserID:    invitedUser.ID,
-				GameID:    game.ID,
-				TurnOrder: maxTurnOrder + 1,
+		// Create the game, seat the creator and invite everyone else.
+		var invitations []Invitation
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&game).Error; err != nil {
+				return err
 			}
-			if err := db.Create(&newPlayer).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add invited player to game"})
-				return
+			if _, err := addPlayerToGame(tx, game.ID, creatorID, RoleOwner); err != nil {
+				return err
 			}
+			invitations, err = createInvitations(tx, cfg, game, creator, req.Players, RolePlayer)
+			return err
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create game"})
+			return
 		}
+		sendInvitations(db, cfg, notifier, game, creator, invitations)
+		recordEvent(db, game.ID, creatorID, "game_created", map[string]interface{}{
+			"game_id": game.ID.String(),
+			"name":    game.Name,
+		})
 
-		c.JSON(http.StatusOK, gin.H{"message": "Game created", "game_id": game.ID})
+		c.JSON(http.StatusOK, gin.H{"message": "Game created", "game_id": game.ID, "invitations": len(invitations)})
 	}
 }
 
-func JoinGameHandler(db *gorm.DB) gin.HandlerFunc {
+// JoinGameHandler seats the current user. Invite-only games additionally
+// require a pending invitation, either for the user's email or passed as
+// the ?invite= token from an invite link. Without an invitation, games that
+// need approval or whose lobby is closed record a join request instead.
+func JoinGameHandler(db *gorm.DB, cfg config.Config, sseManager sse.Broadcaster) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userUUID, err := getUserIDFromContext(c)
 		if err != nil {
@@ -404,6 +387,12 @@ func JoinGameHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		var user User
+		if err := db.First(&user, "id = ?", userUUID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
 		// Check if user is already in the game
 		var existingPlayer Player
 		if err := db.Where("user_id = ? AND game_id = ?", userUUID, gameID).First(&existingPlayer).Error; err == nil {
@@ -411,30 +400,56 @@ func JoinGameHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Add user to game
-		var maxTurnOrder int
-		if err := db.Model(&Player{}).Where("game_id = ?", gameID).Select("COALESCE(MAX(turn_order), -1)").Scan(&maxTurnOrder).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to determine turn order"})
+		invitation, err := findInvitationForJoin(db, cfg, gameID, user, c.Query("invite"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invitations"})
 			return
 		}
-
-		player := Player{
-			UserID:    userUUID,
-			GameID:    gameID,
-			TurnOrder: maxTurnOrder + 1,
+		if invitation == nil {
+			if game.JoinPolicy == JoinPolicyInviteOnly {
+				c.JSON(http.StatusForbidden, gin.H{"error": "this game is invite only"})
+				return
+			}
+			if game.JoinPolicy == JoinPolicyApproval || !game.LobbyOpen {
+				requestToJoin(c, db, sseManager, game, userUUID)
+				return
+			}
 		}
 
-		if err := db.Create(&player).Error; err != nil {
+		var player Player
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if invitation != nil {
+				player, err = acceptInvitation(tx, invitation, userUUID)
+				return err
+			}
+			player, err = addPlayerToGame(tx, gameID, userUUID, RolePlayer)
+			return err
+		})
+		if err == errGameFull {
+			c.JSON(http.StatusConflict, gin.H{"error": "game is full"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join game"})
 			return
 		}
+		broadcastPlayerJoined(db, sseManager, player, userUUID)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Joined game", "player_id": player.ID})
 	}
 }
 
-func GetGameHandler(db *gorm.DB) gin.HandlerFunc {
+// GetGameHandler returns a game to its members. Users who could still join,
+// because the game is open or they hold a pending invitation, see it without
+// the players' emails.
+func GetGameHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
 		gameIDStr := c.Param("id")
 		gameID, err := uuid.Parse(gameIDStr)
 		if err != nil {
@@ -448,7 +463,44 @@ func GetGameHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, game)
+		role, err := roleOf(db, game, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if role != "" {
+			response := newGameResponse(game, true)
+			unread, err := unreadCounts(db, userID, []uuid.UUID{game.ID})
+			if err != nil {
+				fmt.Printf("Warning: failed to count unread messages in game %s: %v\n", game.ID, err)
+			}
+			response.UnreadMessages = unread[game.ID]
+			if game.Settings.simultaneous() && game.Status == GameStatusActive {
+				if response.PendingPlayerIDs, err = pendingPlayerIDs(db, game); err != nil {
+					fmt.Printf("Warning: failed to list pending players in game %s: %v\n", game.ID, err)
+				}
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		if game.JoinPolicy != JoinPolicyOpen {
+			var user User
+			if err := db.First(&user, "id = ?", userID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+				return
+			}
+			invitation, err := findInvitationForJoin(db, cfg, gameID, user, c.Query("invite"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invitations"})
+				return
+			}
+			if invitation == nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this game"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, newGameResponse(game, false))
 	}
 }
 
@@ -464,16 +516,43 @@ func GetUserGamesHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Archived games are hidden unless asked for; ?status= filters by status.
+		query := db.Joins("JOIN players ON players.game_id = games.id").Where("players.user_id = ?", userID)
+		if status := c.Query("status"); status != "" {
+			query = query.Where("games.status = ?", status)
+		} else if c.Query("include_archived") != "true" {
+			query = query.Where("games.status <> ?", GameStatusArchived)
+		}
+
 		var games []Game
-		if err := db.Joins("JOIN players ON players.game_id = games.id").Where("players.user_id = ?", userID).Find(&games).Error; err != nil {
+		if err := query.Preload("Players.User").Find(&games).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve games"})
 			return
 		}
 
-		c.JSON(http.StatusOK, games)
+		gameIDs := make([]uuid.UUID, 0, len(games))
+		for _, g := range games {
+			gameIDs = append(gameIDs, g.ID)
+		}
+		unread, err := unreadCounts(db, userID, gameIDs)
+		if err != nil {
+			fmt.Printf("Warning: failed to count unread messages for user %s: %v\n", userID, err)
+		}
+
+		// Every game listed is one the user plays in.
+		response := make([]GameResponse, 0, len(games))
+		for _, g := range games {
+			game := newGameResponse(g, true)
+			game.UnreadMessages = unread[g.ID]
+			response = append(response, game)
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
+// maxSaveNoteLength limits the note a player can attach to a save.
+const maxSaveNoteLength = 1000
+
 func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 1. Auth & membership check
@@ -508,6 +587,34 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 			return
 		}
 
+		// A substitute uploads into the seat of the away player they cover.
+		if seat, ok := coveredSeat(db, game, userUUID); ok {
+			player = seat
+		} else if player.Role == RoleSpectator {
+			c.JSON(http.StatusForbidden, gin.H{"error": "spectators cannot upload saves"})
+			return
+		}
+
+		if !acceptsUploads(game.Status) {
+			c.JSON(http.StatusConflict, gin.H{"error": "game is " + game.Status})
+			return
+		}
+
+		// Simultaneous games take one save per seat each round.
+		round := 0
+		if game.Settings.simultaneous() {
+			done, err := submittedThisRound(db, game, player)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if done {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a save was already submitted for round %d", game.Round)})
+				return
+			}
+			round = game.Round
+		}
+
 		// 2. Accept multipart upload with disk buffer limits
 		file, header, err := c.Request.FormFile("file")
 		if err != nil {
@@ -516,6 +623,12 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 		}
 		defer file.Close()
 
+		note, err := cleanText(c.PostForm("note"), maxSaveNoteLength)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note " + err.Error()})
+			return
+		}
+
 		// MIME sniffing
 		mimeType, err := detectMimeType(file)
 		if err != nil || !strings.HasPrefix(mimeType, "application/zip") {
@@ -590,6 +703,9 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 			GameID:     gameID,
 			FilePath:   filePath,
 			UploadedBy: userUUID,
+			PlayerID:   &player.ID,
+			Note:       note,
+			Round:      round,
 			CreatedAt:  time.Now(),
 		}
 
@@ -600,25 +716,49 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 			return
 		}
 
+		// The first save starts the game and closes the lobby.
+		if game.Status == GameStatusLobby {
+			previous := game.Status
+			if err := startGame(db, &game); err != nil {
+				fmt.Printf("Warning: failed to start game %s: %v\n", gameID, err)
+			} else {
+				notifyStatusChange(db, sseManager, notifier, game, previous, userUUID)
+			}
+		}
+
 		// 5. Invoke turn-manager: mark current turn complete & assign next player
-		if err := advanceTurn(db, gameID); err != nil {
+		if game.Settings.simultaneous() {
+			completeRound(db, sseManager, notifier, game, userUUID)
+		} else if nextTurnID, err := advanceTurn(db, gameID); err != nil {
 			// Log error but don't fail the request
 			fmt.Printf("Warning: failed to advance turn for game %s: %v\n", gameID, err)
+		} else {
+			publishEvent(db, sseManager, gameID, userUUID, "turn_changed", turnChangedEvent(db, gameID, nextTurnID))
 		}
 
-		// Notify other players
-		var players []Player
-		if err := db.Preload("User").Where("game_id = ? AND user_id != ?", gameID, userUUID).Find(&players).Error; err != nil {
-			fmt.Printf("Warning: failed to get players for notification: %v\n", err)
-		} else {
-			for _, p := range players {
-				if p.User.Email != "" {
-					subject := fmt.Sprintf("New save uploaded for game %s!", game.Name)
-					body := fmt.Sprintf("A new save has been uploaded for %s. It's now your turn!", game.Name)
-					if err := notifier.Notify(p.User.Email, subject, body); err != nil {
-						fmt.Printf("Warning: failed to send email to %s: %v\n", p.User.Email, err)
-					}
-				}
+		// Notify the player whose turn it is now
+		var next Player
+		if err := db.Preload("User").
+			Joins("JOIN games ON games.current_turn_id = players.id").
+			Where("games.id = ? AND players.user_id != ?", gameID, userUUID).
+			First(&next).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				fmt.Printf("Warning: failed to get next player for notification: %v\n", err)
+			}
+		} else if recipient, ok := turnRecipient(db, next); ok && recipient.Email != "" && recipient.ID != userUUID {
+			subject := fmt.Sprintf("New save uploaded for game %s!", game.Name)
+			body := fmt.Sprintf("A new save has been uploaded for %s. It's now your turn!", game.Name)
+			if recipient.ID != next.UserID {
+				body = fmt.Sprintf("A new save has been uploaded for %s. It's %s's turn, and you are covering for them while they are away.",
+					game.Name, displayName(next.User))
+			}
+			if note != "" {
+				var uploader User
+				db.First(&uploader, "id = ?", userUUID)
+				body += fmt.Sprintf("\n\n%s left a note:\n%s", displayName(uploader), note)
+			}
+			if err := notifier.Notify(recipient.Email, subject, body); err != nil {
+				fmt.Printf("Warning: failed to send email to %s: %v\n", recipient.Email, err)
 			}
 		}
 
@@ -626,8 +766,13 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 		notificationMessage := map[string]interface{}{
 			"game_id": gameID.String(),
 			"message": fmt.Sprintf("New save uploaded for game %s!", game.Name),
+			"save_id": save.ID.String(),
+			"note":    note,
+		}
+		if round > 0 {
+			notificationMessage["round"] = round
 		}
-		sseManager.BroadcastMessage("new_save", notificationMessage)
+		publishEvent(db, sseManager, gameID, userUUID, "new_save", notificationMessage)
 
 		// 6. Respond 201 with save metadata
 		c.JSON(http.StatusCreated, gin.H{
@@ -636,21 +781,17 @@ func UploadSaveHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifie
 			"game_id":     save.GameID,
 			"file_path":   save.FilePath,
 			"uploaded_by": save.UploadedBy,
+			"note":        save.Note,
 			"created_at":  save.CreatedAt,
 		})
 	}
 }
 
-type MessageRequest struct {
-	Message string `json:"message" binding:"required"`
-}
-
-func MessageHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+func DeleteGameHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 1. Auth & Permission Check
 		userUUID, err := getUserIDFromContext(c)
 		if err != nil {
-			fmt.Println("auth error", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
 			return
 		}
@@ -672,104 +813,25 @@ func MessageHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
 			return
 		}
 
-		var existingPlayer Player
-		if err := db.Preload("User").Where("user_id = ? AND game_id = ?", userUUID, gameID).First(&existingPlayer).Error; err != nil {
-			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("can't get existing player %s from game %s", userUUID, gameID)})
-			return
-		}
-
-		var req MessageRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "message is required"})
-			return
-		}
-
-		sseManager.BroadcastMessage("broadcast", existingPlayer.User.Email+": "+req.Message)
-		c.JSON(http.StatusAccepted, gin.H{
-			"message": "message sent",
-		})
-	}
-}
-
-func DeleteGameHandler(db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 1. Auth & Permission Check
-		userUUID, err := getUserIDFromContext(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
-			return
-		}
-
-		gameIDStr := c.Param("id")
-		gameID, err := uuid.Parse(gameIDStr)
+		role, err := roleOf(db, game, userUUID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
-			return
-		}
-
-		var game Game
-		if err := db.First(&game, "id = ?", gameID).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
-				return
-			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 			return
 		}
-
-		if game.CreatorID != userUUID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "only the creator can delete this game"})
-			return
-		}
-
-		// 2. Cascading Delete
-		// Start a transaction
-		tx := db.Begin()
-		if tx.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start transaction"})
-			return
-		}
-
-		// Delete players
-		if err := tx.Where("game_id = ?", gameID).Delete(&Player{}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete players"})
-			return
-		}
-
-		// Delete saves and their files
-		var saves []Save
-		if err := tx.Where("game_id = ?", gameID).Find(&saves).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find saves"})
+		if !hasPermission(role, PermissionOwnGame) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can delete this game"})
 			return
 		}
 
-		for _, save := range saves {
-			if err := os.Remove(save.FilePath); err != nil {
-				// Log error but continue, as the DB record is more important
-				fmt.Printf("Warning: failed to delete save file %s: %v\n", save.FilePath, err)
-			}
-		}
-
-		if err := tx.Where("game_id = ?", gameID).Delete(&Save{}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete save records"})
-			return
-		}
-
-		// Delete the game itself
-		if err := tx.Delete(&game).Error; err != nil {
-			tx.Rollback()
+		// 2. Soft delete. Players, saves and files are kept so the owner can
+		// restore the game; PurgeDeletedGames removes them after the grace period.
+		if err := db.Delete(&game).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete game"})
 			return
 		}
-
-		// Commit the transaction
-		if err := tx.Commit().Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to commit transaction"})
-			return
-		}
+		recordEvent(db, game.ID, userUUID, "game_deleted", map[string]interface{}{
+			"game_id": game.ID.String(),
+		})
 
 		c.JSON(http.StatusOK, gin.H{"message": "game deleted successfully"})
 	}
@@ -0,0 +1,446 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+const (
+	linkTokenAudience  = "account-link"
+	linkTokenTTL       = 10 * time.Minute
+	linkCookie         = "oauthlink"
+	mergeTokenAudience = "account-merge"
+)
+
+var errIdentityInUse = fmt.Errorf("identity belongs to another user")
+
+type MergeRequest struct {
+	MergeToken string `json:"merge_token" binding:"required"`
+}
+
+// resolveIdentity finds the user a provider identity belongs to. Unknown
+// identities are attached to the user with the same verified email, or to a
+// newly created user.
+func resolveIdentity(db *gorm.DB, identity ProviderIdentity) (User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error
+		if err == nil {
+			if identity.Email != "" && identity.Email != existing.Email {
+				tx.Model(&existing).Update("email", identity.Email)
+			}
+			if err := tx.First(&user, "id = ?", existing.UserID).Error; err != nil {
+				return err
+			}
+			return applyProviderProfile(tx, &user, identity)
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		verifiedEmail := ""
+		if identity.EmailVerified {
+			verifiedEmail = normalizeEmail(identity.Email)
+		}
+
+		if verifiedEmail != "" {
+			err = tx.Where("LOWER(email) = ?", verifiedEmail).First(&user).Error
+		} else {
+			err = gorm.ErrRecordNotFound
+		}
+		if err == gorm.ErrRecordNotFound {
+			user = User{Email: verifiedEmail, AuthProvider: identity.Provider}
+			err = tx.Create(&user).Error
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyProviderProfile(tx, &user, identity); err != nil {
+			return err
+		}
+
+		return tx.Create(&UserIdentity{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+	return user, err
+}
+
+// linkIdentity attaches a provider identity to userID. An identity that
+// already belongs to another account is never taken over; it fails with
+// errIdentityInUse.
+func linkIdentity(db *gorm.DB, userID uuid.UUID, identity ProviderIdentity) (User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		var existing UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			if err := applyProviderProfile(tx, &user, identity); err != nil {
+				return err
+			}
+			return tx.Create(&UserIdentity{
+				UserID:   userID,
+				Provider: identity.Provider,
+				Subject:  identity.Subject,
+				Email:    identity.Email,
+			}).Error
+		case err != nil:
+			return err
+		case existing.UserID == userID:
+			return nil
+		default:
+			return errIdentityInUse
+		}
+	})
+	return user, err
+}
+
+// MergeUsers moves everything owned by source to target and deletes source.
+// When both users play in the same game, the two seats are combined with
+// mergeSeats.
+func MergeUsers(tx *gorm.DB, target, source uuid.UUID) error {
+	if target == source {
+		return fmt.Errorf("cannot merge a user into itself")
+	}
+
+	var sourceUser, targetUser User
+	if err := tx.First(&sourceUser, "id = ?", source).Error; err != nil {
+		return err
+	}
+	if err := tx.First(&targetUser, "id = ?", target).Error; err != nil {
+		return err
+	}
+
+	var sourcePlayers []Player
+	if err := tx.Where("user_id = ?", source).Find(&sourcePlayers).Error; err != nil {
+		return err
+	}
+	for _, sp := range sourcePlayers {
+		var tp Player
+		err := tx.Where("user_id = ? AND game_id = ?", target, sp.GameID).First(&tp).Error
+		if err == gorm.ErrRecordNotFound {
+			if err := tx.Model(&Player{}).Where("id = ?", sp.ID).Update("user_id", target).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := mergeSeats(tx, tp, sp); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Unscoped().Model(&Game{}).Where("creator_id = ?", source).Update("creator_id", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Save{}).Where("uploaded_by = ?", source).Update("uploaded_by", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&JoinRequest{}).Where("user_id = ?", source).Update("user_id", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Invitation{}).Where("invited_by = ?", source).Update("invited_by", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&ChatMessage{}).Where("author_id = ?", source).Update("author_id", target).Error; err != nil {
+		return err
+	}
+	// A reaction both accounts made to the same message is kept once.
+	if err := tx.Where("user_id = ? AND EXISTS (SELECT 1 FROM chat_reactions AS kept WHERE kept.user_id = ? AND kept.message_id = chat_reactions.message_id AND kept.emoji = chat_reactions.emoji)", source, target).
+		Delete(&ChatReaction{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&ChatReaction{}).Where("user_id = ?", source).Update("user_id", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Player{}).Where("away_substitute_id = ?", source).Update("away_substitute_id", target).Error; err != nil {
+		return err
+	}
+	// Nobody covers for themselves.
+	if err := tx.Model(&Player{}).Where("user_id = ? AND away_substitute_id = ?", target, target).Update("away_substitute_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&GameEvent{}).Where("actor_id = ?", source).Update("actor_id", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&UserIdentity{}).Where("user_id = ?", source).Update("user_id", target).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", source).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", source).Delete(&LoginCode{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Delete(&User{}, "id = ?", source).Error; err != nil {
+		return err
+	}
+
+	// Keep an email address if the surviving account has none (e.g. Steam).
+	if targetUser.Email == "" && sourceUser.Email != "" {
+		if err := tx.Model(&User{}).Where("id = ?", target).Update("email", sourceUser.Email).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSeats folds dropped, a second seat of the same person in a game, into
+// kept. Kept ends up with the stronger role, the turn, the saves and clock
+// time of both seats, and an away period if only dropped had one. The turn
+// order is then closed up as when a player leaves.
+func mergeSeats(tx *gorm.DB, kept, dropped Player) error {
+	updates := map[string]interface{}{
+		"time_used_seconds": kept.TimeUsedSeconds + dropped.TimeUsedSeconds,
+	}
+	if roleRank[dropped.Role] > roleRank[kept.Role] {
+		updates["role"] = dropped.Role
+		if kept.Role == RoleSpectator {
+			updates["turn_order"] = dropped.TurnOrder
+		}
+	}
+	if kept.AwayUntil == nil && dropped.AwayUntil != nil {
+		updates["away_until"] = dropped.AwayUntil
+		updates["away_substitute_id"] = dropped.AwaySubstituteID
+	}
+	if kept.OutOfTimeAt == nil && dropped.OutOfTimeAt != nil {
+		updates["out_of_time_at"] = dropped.OutOfTimeAt
+	}
+	if err := tx.Model(&Player{}).Where("id = ?", kept.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&Save{}).Where("player_id = ?", dropped.ID).Update("player_id", kept.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&Game{}).Where("id = ? AND current_turn_id = ?", dropped.GameID, dropped.ID).
+		Update("current_turn_id", kept.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Delete(&Player{}, "id = ?", dropped.ID).Error; err != nil {
+		return err
+	}
+	return compactTurnOrder(tx, kept.GameID)
+}
+
+// GetIdentitiesHandler lists the login methods linked to the current user.
+func GetIdentitiesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var identities []UserIdentity
+		if err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve identities"})
+			return
+		}
+		c.JSON(http.StatusOK, identities)
+	}
+}
+
+// LinkIdentityHandler starts linking another login method to the current
+// user. It sets the OAuth state and link cookies in the caller's browser and
+// returns the provider login URL to open there; the callback then attaches
+// the identity instead of signing in as a different user. The link token is
+// bound to that state, so it cannot be carried over to another browser.
+func LinkIdentityHandler(cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		provider, ok := GetProvider(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
+			return
+		}
+
+		state := GenerateStateOauthCookie(c, cfg)
+		now := time.Now()
+		linkToken, err := SignToken(cfg, Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        hashToken(state),
+				Subject:   userID.String(),
+				Issuer:    cfg.JwtIssuer,
+				Audience:  jwt.ClaimStrings{linkTokenAudience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(linkTokenTTL)),
+			},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate link token"})
+			return
+		}
+
+		c.SetSameSite(http.SameSiteNoneMode)
+		c.SetCookie(linkCookie, linkToken, int(linkTokenTTL.Seconds()), "/", "", true, true)
+		c.JSON(http.StatusOK, gin.H{"url": provider.LoginURL(state)})
+	}
+}
+
+// UnlinkIdentityHandler removes a login method. The last one cannot be
+// removed, since the account would become unreachable.
+func UnlinkIdentityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		identityID, err := uuid.Parse(c.Param("identityId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+			return
+		}
+
+		var identity UserIdentity
+		if err := db.Where("id = ? AND user_id = ?", identityID, userID).First(&identity).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+			return
+		}
+
+		var count int64
+		if err := db.Model(&UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if count <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot remove the only login method"})
+			return
+		}
+
+		if err := db.Delete(&identity).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove identity"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "identity removed"})
+	}
+}
+
+// offerMerge answers a link callback for an identity that belongs to another
+// account. Signing in with it while linking proves control of both accounts,
+// so the user is sent back to the frontend with a merge token naming the
+// other account, which MergeUsersHandler accepts from the linking user only.
+func offerMerge(c *gin.Context, db *gorm.DB, cfg config.Config, userID uuid.UUID, identity ProviderIdentity) {
+	var existing UserIdentity
+	if err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find the linked account"})
+		return
+	}
+
+	now := time.Now()
+	mergeToken, err := SignToken(cfg, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        existing.UserID.String(),
+			Subject:   userID.String(),
+			Issuer:    cfg.JwtIssuer,
+			Audience:  jwt.ClaimStrings{mergeTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(linkTokenTTL)),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate merge token"})
+		return
+	}
+
+	query := url.Values{}
+	query.Set("merge", mergeToken)
+	c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("%s?%s", cfg.FrontendUrl, query.Encode()))
+}
+
+// MergeUsersHandler merges the account named by a merge token from a link
+// callback into the current user. Only the user who started the link can use
+// the token, and it stops working once the other account is gone.
+func MergeUsersHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var req MergeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "merge_token is required"})
+			return
+		}
+		claims, err := ParseToken(cfg, req.MergeToken, mergeTokenAudience)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired merge token"})
+			return
+		}
+		if claims.Subject != userID.String() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this merge was started by another account"})
+			return
+		}
+		sourceID, err := uuid.Parse(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired merge token"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return MergeUsers(tx, userID, sourceID)
+		})
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the other account no longer exists"})
+			return
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to merge user %s into %s: %v\n", sourceID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge accounts"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "accounts merged"})
+	}
+}
+
+// linkingUserID returns the user a pending link cookie was issued for, if it
+// was issued together with the OAuth state of this callback.
+func linkingUserID(c *gin.Context, cfg config.Config, state string) (uuid.UUID, bool) {
+	linkToken, err := c.Cookie(linkCookie)
+	if err != nil || linkToken == "" {
+		return uuid.Nil, false
+	}
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(linkCookie, "", -1, "/", "", true, true)
+
+	claims, err := ParseToken(cfg, linkToken, linkTokenAudience)
+	if err != nil || claims.ID != hashToken(state) {
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return userID, true
+}
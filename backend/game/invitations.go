@@ -0,0 +1,367 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+	"panzerstadt/async-multiplayer/sse"
+)
+
+const inviteAudience = "game-invite"
+
+type InviteRequest struct {
+	Emails []string `json:"emails" binding:"required,min=1,dive,email"`
+	// Role is "player" (the default) or "spectator".
+	Role string `json:"role"`
+}
+
+// InvitationResponseRequest optionally carries the token from an invite link,
+// which lets a user answer an invitation sent to a different email address.
+type InvitationResponseRequest struct {
+	Token string `json:"token"`
+}
+
+func validJoinPolicy(policy string) bool {
+	return policy == JoinPolicyOpen || policy == JoinPolicyInviteOnly || policy == JoinPolicyApproval
+}
+
+func signInviteToken(cfg config.Config, invitation Invitation) (string, error) {
+	return SignToken(cfg, Claims{
+		Email: invitation.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        invitation.ID.String(),
+			Subject:   invitation.GameID.String(),
+			Issuer:    cfg.JwtIssuer,
+			Audience:  jwt.ClaimStrings{inviteAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(invitation.ExpiresAt),
+		},
+	})
+}
+
+// inviteTokenMatches reports whether token is a valid invite link for invitation.
+func inviteTokenMatches(cfg config.Config, token string, invitation Invitation) bool {
+	if token == "" {
+		return false
+	}
+	claims, err := ParseToken(cfg, token, inviteAudience)
+	return err == nil && claims.ID == invitation.ID.String()
+}
+
+// createInvitations records pending invitations for emails that are neither
+// the inviter, already playing, nor already invited. Accepting one seats the
+// user with role.
+func createInvitations(tx *gorm.DB, cfg config.Config, game Game, inviter User, emails []string, role string) ([]Invitation, error) {
+	var created []Invitation
+	seen := map[string]bool{strings.ToLower(inviter.Email): true}
+
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		key := strings.ToLower(email)
+		if email == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var playing int64
+		if err := tx.Model(&Player{}).
+			Joins("JOIN users ON users.id = players.user_id").
+			Where("players.game_id = ? AND LOWER(users.email) = ?", game.ID, key).
+			Count(&playing).Error; err != nil {
+			return nil, err
+		}
+		if playing > 0 {
+			continue
+		}
+
+		var pending int64
+		if err := tx.Model(&Invitation{}).
+			Where("game_id = ? AND LOWER(email) = ? AND status = ? AND expires_at > ?", game.ID, key, InvitationPending, time.Now()).
+			Count(&pending).Error; err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			continue
+		}
+
+		invitation := Invitation{
+			GameID:    game.ID,
+			Email:     email,
+			InvitedBy: inviter.ID,
+			Role:      role,
+			Status:    InvitationPending,
+			ExpiresAt: time.Now().Add(cfg.InviteTTL),
+		}
+		if err := tx.Create(&invitation).Error; err != nil {
+			return nil, err
+		}
+		created = append(created, invitation)
+	}
+	return created, nil
+}
+
+// sendInvitations emails a signed join link for each invitation. Failures are
+// logged; the invitation stays pending and can be answered from the app.
+func sendInvitations(db *gorm.DB, cfg config.Config, notifier Notifier, game Game, inviter User, invitations []Invitation) {
+	for _, invitation := range invitations {
+		// Invitees who already have an account see dates in their timezone.
+		var invitee User
+		db.Where("LOWER(email) = ?", normalizeEmail(invitation.Email)).Limit(1).Find(&invitee)
+
+		token, err := signInviteToken(cfg, invitation)
+		if err != nil {
+			fmt.Printf("Warning: failed to sign invitation %s: %v\n", invitation.ID, err)
+			continue
+		}
+		link := fmt.Sprintf("%s/invites/%s?token=%s", cfg.FrontendUrl, invitation.ID, url.QueryEscape(token))
+		subject := fmt.Sprintf("You've been invited to join %s!", game.Name)
+		verb := "play"
+		if invitation.Role == RoleSpectator {
+			verb = "watch"
+		}
+		body := fmt.Sprintf("%s has invited you to %s %s.\n\nAccept or decline the invitation here:\n%s\n\nThe invitation expires on %s.",
+			inviter.Email, verb, game.Name, link, localDate(invitee, invitation.ExpiresAt))
+		if err := notifier.Notify(invitation.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send invitation to %s: %v\n", invitation.Email, err)
+		}
+	}
+}
+
+// expireIfStale marks a pending invitation past its expiry as expired.
+func expireIfStale(db *gorm.DB, invitation *Invitation) {
+	if invitation.Status == InvitationPending && time.Now().After(invitation.ExpiresAt) {
+		invitation.Status = InvitationExpired
+		db.Model(invitation).Update("status", InvitationExpired)
+	}
+}
+
+// acceptInvitation seats the user with the invitation's role and closes the
+// invitation.
+func acceptInvitation(tx *gorm.DB, invitation *Invitation, userID uuid.UUID) (Player, error) {
+	role := invitation.Role
+	if role == "" {
+		role = RolePlayer
+	}
+	player, err := addPlayerToGame(tx, invitation.GameID, userID, role)
+	if err != nil && err != errAlreadyParticipant {
+		return Player{}, err
+	}
+
+	now := time.Now()
+	invitation.Status = InvitationAccepted
+	invitation.RespondedAt = &now
+	if err := tx.Model(invitation).Updates(map[string]interface{}{
+		"status":       InvitationAccepted,
+		"responded_at": now,
+	}).Error; err != nil {
+		return Player{}, err
+	}
+	return player, nil
+}
+
+// findInvitationForJoin returns the pending invitation that lets user join
+// game, either through an invite link token or by matching email.
+func findInvitationForJoin(db *gorm.DB, cfg config.Config, gameID uuid.UUID, user User, token string) (*Invitation, error) {
+	var invitations []Invitation
+	if err := db.Where("game_id = ? AND status = ? AND expires_at > ?", gameID, InvitationPending, time.Now()).
+		Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	for i := range invitations {
+		if inviteTokenMatches(cfg, token, invitations[i]) ||
+			(user.Email != "" && strings.EqualFold(invitations[i].Email, user.Email)) {
+			return &invitations[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateInvitesHandler invites more people to a game.
+func CreateInvitesHandler(db *gorm.DB, cfg config.Config, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+		userID, _ := getUserIDFromContext(c)
+
+		var req InviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a list of valid emails is required"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = RolePlayer
+		}
+		if req.Role != RolePlayer && req.Role != RoleSpectator {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be \"player\" or \"spectator\""})
+			return
+		}
+
+		var inviter User
+		if err := db.First(&inviter, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find inviter details"})
+			return
+		}
+
+		var invitations []Invitation
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			invitations, err = createInvitations(tx, cfg, game, inviter, req.Emails, req.Role)
+			return err
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitations"})
+			return
+		}
+		sendInvitations(db, cfg, notifier, game, inviter, invitations)
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Invitations sent", "invitations": invitations})
+	}
+}
+
+// GetGameInvitesHandler lists a game's invitations for its owner and admins.
+func GetGameInvitesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		var invitations []Invitation
+		if err := db.Where("game_id = ?", game.ID).Order("created_at ASC").Find(&invitations).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invitations"})
+			return
+		}
+		for i := range invitations {
+			expireIfStale(db, &invitations[i])
+		}
+		c.JSON(http.StatusOK, invitations)
+	}
+}
+
+// GetUserInvitesHandler lists pending invitations addressed to the current
+// user's email.
+func GetUserInvitesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		invitations := []Invitation{}
+		if user.Email != "" {
+			if err := db.Where("LOWER(email) = ? AND status = ? AND expires_at > ?", strings.ToLower(user.Email), InvitationPending, time.Now()).
+				Order("created_at DESC").Find(&invitations).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invitations"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, invitations)
+	}
+}
+
+// loadInvitationForResponse loads the invitation in the :inviteId parameter
+// and checks that the current user may answer it. It writes the error
+// response itself and returns ok=false on failure.
+func loadInvitationForResponse(c *gin.Context, db *gorm.DB, cfg config.Config) (invitation Invitation, userID uuid.UUID, ok bool) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+		return
+	}
+
+	inviteID, err := uuid.Parse(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+		return
+	}
+	if err := db.First(&invitation, "id = ?", inviteID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var req InvitationResponseRequest
+	c.ShouldBindJSON(&req)
+	if !inviteTokenMatches(cfg, req.Token, invitation) &&
+		(user.Email == "" || !strings.EqualFold(user.Email, invitation.Email)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this invitation was sent to someone else"})
+		return
+	}
+
+	expireIfStale(db, &invitation)
+	switch invitation.Status {
+	case InvitationPending:
+		return invitation, userID, true
+	case InvitationExpired:
+		c.JSON(http.StatusGone, gin.H{"error": "invitation has expired"})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "invitation already " + invitation.Status})
+	}
+	return
+}
+
+func AcceptInviteHandler(db *gorm.DB, cfg config.Config, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitation, userID, ok := loadInvitationForResponse(c, db, cfg)
+		if !ok {
+			return
+		}
+
+		var player Player
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			player, err = acceptInvitation(tx, &invitation, userID)
+			return err
+		}); err == errGameFull {
+			c.JSON(http.StatusConflict, gin.H{"error": "game is full"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join game"})
+			return
+		}
+		broadcastPlayerJoined(db, sseManager, player, userID)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted", "game_id": invitation.GameID, "player_id": player.ID})
+	}
+}
+
+func DeclineInviteHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitation, _, ok := loadInvitationForResponse(c, db, cfg)
+		if !ok {
+			return
+		}
+
+		if err := db.Model(&invitation).Updates(map[string]interface{}{
+			"status":       InvitationDeclined,
+			"responded_at": time.Now(),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decline invitation"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+	}
+}
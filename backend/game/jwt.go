@@ -0,0 +1,58 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+// Claims is the payload of every token issued by the server.
+type Claims struct {
+	Email string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// SignToken signs claims with the active key and records its id in the
+// token's kid header.
+func SignToken(cfg config.Config, claims Claims) (string, error) {
+	activeKeyID, keys := cfg.SigningKeys()
+	key, ok := keys[activeKeyID]
+	if !ok {
+		return "", fmt.Errorf("signing key %q is not configured", activeKeyID)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = activeKeyID
+	return token.SignedString(key)
+}
+
+// ParseToken verifies a token's signature against the key named by its kid
+// header and checks expiry, issuer and the expected audience.
+func ParseToken(cfg config.Config, tokenString string, audience string) (*Claims, error) {
+	activeKeyID, keys := cfg.SigningKeys()
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = activeKeyID
+		}
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(cfg.JwtIssuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
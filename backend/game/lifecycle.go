@@ -0,0 +1,175 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+type FinishGameRequest struct {
+	WinnerPlayerID *uuid.UUID `json:"winner_player_id"`
+}
+
+// acceptsUploads reports whether saves can be uploaded in the given status.
+func acceptsUploads(status string) bool {
+	return status == GameStatusLobby || status == GameStatusActive
+}
+
+// startGame moves a lobby game to active, closing the lobby and handing the
+// first turn to the first seat if nobody holds it yet. Simultaneous games
+// have no turn to hand out.
+func startGame(tx *gorm.DB, game *Game) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":          GameStatusActive,
+		"lobby_open":      false,
+		"turn_started_at": now,
+	}
+	if game.CurrentTurnID == nil && !game.Settings.simultaneous() {
+		var first Player
+		err := tx.Scopes(seated).Where("game_id = ?", game.ID).Order("turn_order ASC").First(&first).Error
+		if err == nil {
+			updates["current_turn_id"] = first.ID
+		} else if err != gorm.ErrRecordNotFound {
+			return err
+		}
+	}
+	if err := tx.Model(game).Updates(updates).Error; err != nil {
+		return err
+	}
+	game.Status = GameStatusActive
+	game.LobbyOpen = false
+	game.TurnStartedAt = &now
+	return nil
+}
+
+// notifyStatusChange tells everyone in the game about a status change.
+func notifyStatusChange(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, game Game, previous string, actorID uuid.UUID) {
+	publishEvent(db, sseManager, game.ID, actorID, "game_status_changed", map[string]interface{}{
+		"game_id":         game.ID.String(),
+		"status":          game.Status,
+		"previous_status": previous,
+		"winner_id":       game.WinnerID,
+	})
+
+	var players []Player
+	if err := db.Preload("User").Where("game_id = ?", game.ID).Find(&players).Error; err != nil {
+		fmt.Printf("Warning: failed to get players for notification: %v\n", err)
+		return
+	}
+	subject := fmt.Sprintf("%s is now %s", game.Name, game.Status)
+	body := fmt.Sprintf("The game %s has changed from %s to %s.", game.Name, previous, game.Status)
+	for _, p := range players {
+		if p.User.Email == "" || p.isAway(time.Now()) {
+			continue
+		}
+		if err := notifier.Notify(p.User.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", p.User.Email, err)
+		}
+	}
+}
+
+// transitionGameHandler builds an admin-only handler that moves a game from
+// one of the given statuses to the target status.
+func transitionGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, from []string, target string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		previous := game.Status
+		allowed := false
+		for _, status := range from {
+			allowed = allowed || status == previous
+		}
+		if !allowed {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot change a %s game to %s", previous, target)})
+			return
+		}
+
+		var updates map[string]interface{}
+		if target == GameStatusFinished {
+			var req FinishGameRequest
+			if c.Request.ContentLength > 0 {
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+					return
+				}
+			}
+			if req.WinnerPlayerID != nil {
+				var winner Player
+				if err := db.Where("id = ? AND game_id = ?", *req.WinnerPlayerID, game.ID).First(&winner).Error; err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "winner must be a player in this game"})
+					return
+				}
+			}
+			now := time.Now()
+			game.WinnerID = req.WinnerPlayerID
+			game.FinishedAt = &now
+			updates = map[string]interface{}{
+				"status":      target,
+				"winner_id":   req.WinnerPlayerID,
+				"finished_at": now,
+				"lobby_open":  false,
+			}
+		}
+
+		var err error
+		switch {
+		case target == GameStatusActive && previous == GameStatusLobby:
+			err = startGame(db, &game)
+		case updates != nil:
+			err = db.Model(&game).Updates(updates).Error
+		default:
+			err = db.Model(&game).Update("status", target).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update game status"})
+			return
+		}
+		game.Status = target
+
+		// The clock only runs while the game is active.
+		if previous == GameStatusActive || (target == GameStatusActive && previous != GameStatusLobby) {
+			if err := switchClock(db, &game, target == GameStatusActive); err != nil {
+				fmt.Printf("Warning: failed to update the clock of game %s: %v\n", game.ID, err)
+			}
+		}
+
+		notifyStatusChange(db, sseManager, notifier, game, previous, actorOf(c))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Game status updated", "status": game.Status, "winner_id": game.WinnerID})
+	}
+}
+
+func StartGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return transitionGameHandler(db, sseManager, notifier, []string{GameStatusLobby}, GameStatusActive)
+}
+
+// PauseGameHandler pauses an active game; uploads are refused until it is
+// resumed.
+func PauseGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return transitionGameHandler(db, sseManager, notifier, []string{GameStatusActive}, GameStatusPaused)
+}
+
+func ResumeGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return transitionGameHandler(db, sseManager, notifier, []string{GameStatusPaused}, GameStatusActive)
+}
+
+// FinishGameHandler ends a game, optionally recording the winning player.
+func FinishGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return transitionGameHandler(db, sseManager, notifier,
+		[]string{GameStatusLobby, GameStatusActive, GameStatusPaused}, GameStatusFinished)
+}
+
+// ArchiveGameHandler hides a finished game from game lists.
+func ArchiveGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return transitionGameHandler(db, sseManager, notifier, []string{GameStatusFinished}, GameStatusArchived)
+}
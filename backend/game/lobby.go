@@ -0,0 +1,180 @@
+package game
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+type LobbyRequest struct {
+	Open *bool `json:"open" binding:"required"`
+}
+
+// requestToJoin records a pending join request for the owner or an admin to
+// review.
+func requestToJoin(c *gin.Context, db *gorm.DB, sseManager sse.Broadcaster, game Game, userID uuid.UUID) {
+	var pending int64
+	if err := db.Model(&JoinRequest{}).
+		Where("game_id = ? AND user_id = ? AND status = ?", game.ID, userID, JoinRequestPending).
+		Count(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check join requests"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "join request already pending"})
+		return
+	}
+
+	request := JoinRequest{GameID: game.ID, UserID: userID, Status: JoinRequestPending}
+	if err := db.Create(&request).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create join request"})
+		return
+	}
+
+	publishEvent(db, sseManager, game.ID, userID, "join_requested", map[string]interface{}{
+		"game_id":    game.ID.String(),
+		"request_id": request.ID.String(),
+		"user_id":    userID.String(),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Join request sent", "request_id": request.ID})
+}
+
+// SetLobbyHandler opens or closes a game's lobby. While the lobby is closed,
+// joining without an invitation files a join request instead.
+func SetLobbyHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		var req LobbyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "open is required"})
+			return
+		}
+
+		if err := db.Model(&game).Update("lobby_open", *req.Open).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lobby"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "lobby_changed", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"lobby_open": *req.Open,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Lobby updated", "lobby_open": *req.Open})
+	}
+}
+
+// GetJoinRequestsHandler lists a game's pending join requests.
+func GetJoinRequestsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		var requests []JoinRequest
+		if err := db.Preload("User").
+			Where("game_id = ? AND status = ?", game.ID, JoinRequestPending).
+			Order("created_at ASC").Find(&requests).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve join requests"})
+			return
+		}
+		response := make([]JoinRequestResponse, 0, len(requests))
+		for _, request := range requests {
+			response = append(response, newJoinRequestResponse(request))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// loadPendingJoinRequest loads the pending :requestId of game.
+func loadPendingJoinRequest(c *gin.Context, db *gorm.DB, game Game) (request JoinRequest, ok bool) {
+	requestID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "join request not found"})
+		return
+	}
+	if err := db.Where("id = ? AND game_id = ?", requestID, game.ID).First(&request).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "join request not found"})
+		return
+	}
+	if request.Status != JoinRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "join request already " + request.Status})
+		return
+	}
+	return request, true
+}
+
+func ApproveJoinRequestHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+		request, ok := loadPendingJoinRequest(c, db, game)
+		if !ok {
+			return
+		}
+
+		var player Player
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			player, err = addPlayerToGame(tx, game.ID, request.UserID, RolePlayer)
+			if err != nil && err != errAlreadyParticipant {
+				return err
+			}
+			return tx.Model(&request).Updates(map[string]interface{}{
+				"status":       JoinRequestApproved,
+				"responded_at": time.Now(),
+			}).Error
+		})
+		if err == errGameFull {
+			c.JSON(http.StatusConflict, gin.H{"error": "game is full"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve join request"})
+			return
+		}
+		broadcastPlayerJoined(db, sseManager, player, actorOf(c))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Join request approved", "player_id": player.ID})
+	}
+}
+
+func RejectJoinRequestHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+		request, ok := loadPendingJoinRequest(c, db, game)
+		if !ok {
+			return
+		}
+
+		if err := db.Model(&request).Updates(map[string]interface{}{
+			"status":       JoinRequestRejected,
+			"responded_at": time.Now(),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject join request"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "join_request_rejected", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"request_id": request.ID.String(),
+			"user_id":    request.UserID.String(),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Join request rejected"})
+	}
+}
@@ -0,0 +1,198 @@
+package game
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+const (
+	magicLinkAudience = "magic-link"
+	emailProvider     = "email"
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// RequestMagicLinkHandler emails a single-use login link to a known or
+// invited address.
+// The response is the same whether or not the address is known, so it cannot
+// be used to discover registered emails.
+func RequestMagicLinkHandler(db *gorm.DB, cfg config.Config, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MagicLinkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a valid email is required"})
+			return
+		}
+		email := normalizeEmail(req.Email)
+		accepted := gin.H{"message": "if the address is registered, a login link has been sent"}
+
+		var user User
+		if err := db.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			// People invited to a game get an account on their first login.
+			var invited int64
+			db.Model(&Invitation{}).
+				Where("LOWER(email) = ? AND status = ? AND expires_at > ?", email, InvitationPending, time.Now()).
+				Count(&invited)
+			if invited == 0 {
+				c.JSON(http.StatusAccepted, accepted)
+				return
+			}
+			user = User{Email: email, AuthProvider: emailProvider}
+			if err := db.Create(&user).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+		}
+
+		link := MagicLink{UserID: user.ID, ExpiresAt: time.Now().Add(cfg.MagicLinkTTL)}
+		if err := db.Create(&link).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create login link"})
+			return
+		}
+
+		token, err := SignToken(cfg, Claims{
+			Email: user.Email,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        link.ID.String(),
+				Subject:   user.ID.String(),
+				Issuer:    cfg.JwtIssuer,
+				Audience:  jwt.ClaimStrings{magicLinkAudience},
+				IssuedAt:  jwt.NewNumericDate(link.CreatedAt),
+				ExpiresAt: jwt.NewNumericDate(link.ExpiresAt),
+			},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create login link"})
+			return
+		}
+
+		loginURL := fmt.Sprintf("%s/auth/email/callback?token=%s", cfg.BackendUrl, url.QueryEscape(token))
+		subject := "Your Async Multiplayer login link"
+		body := fmt.Sprintf("Click the link below to log in. It can be used once and expires in %d minutes.\n\n%s",
+			int(cfg.MagicLinkTTL.Minutes()), loginURL)
+		if err := notifier.Notify(user.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send login link to %s: %v\n", user.Email, err)
+		}
+
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// parseMagicLink checks a login link token and returns its claims and link
+// ID. It does not use the link up.
+func parseMagicLink(cfg config.Config, token string) (*Claims, uuid.UUID, bool) {
+	claims, err := ParseToken(cfg, token, magicLinkAudience)
+	if err != nil {
+		return nil, uuid.Nil, false
+	}
+	linkID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, uuid.Nil, false
+	}
+	return claims, linkID, true
+}
+
+var confirmLoginPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Log in</title></head>
+<body>
+<form method="post" action="/auth/email/callback">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Log in to Async Multiplayer</button>
+</form>
+</body>
+</html>
+`))
+
+// MagicLinkCallbackHandler is where a login link points. It only asks the
+// user to confirm, so that mail scanners and link previews that fetch the
+// link do not use it up; ConfirmMagicLinkHandler logs in.
+func MagicLinkCallbackHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		claims, linkID, ok := parseMagicLink(cfg, token)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+			return
+		}
+		var usable int64
+		if err := db.Model(&MagicLink{}).
+			Where("id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", linkID, claims.Subject, time.Now()).
+			Count(&usable).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check login link"})
+			return
+		}
+		if usable == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login link has already been used or has expired"})
+			return
+		}
+
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Status(http.StatusOK)
+		if err := confirmLoginPage.Execute(c.Writer, token); err != nil {
+			fmt.Printf("Warning: failed to render login confirmation: %v\n", err)
+		}
+	}
+}
+
+// ConfirmMagicLinkHandler consumes a login link posted from the confirmation
+// page and signs the user in the same way as a provider login.
+func ConfirmMagicLinkHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, linkID, ok := parseMagicLink(cfg, c.PostForm("token"))
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+			return
+		}
+
+		var user User
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&MagicLink{}).
+				Where("id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", linkID, claims.Subject, time.Now()).
+				Update("used_at", time.Now())
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errTokenAlreadyUsed
+			}
+
+			if err := tx.First(&user, "id = ?", claims.Subject).Error; err != nil {
+				return err
+			}
+
+			// Receiving the email proves ownership of the address, which
+			// makes it a login method of its own.
+			identity := UserIdentity{UserID: user.ID, Provider: emailProvider, Subject: normalizeEmail(user.Email), Email: user.Email}
+			return tx.Where(UserIdentity{Provider: emailProvider, Subject: identity.Subject}).FirstOrCreate(&identity).Error
+		})
+		if err == errTokenAlreadyUsed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login link has already been used or has expired"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+			return
+		}
+
+		completeLogin(c, db, cfg, user)
+	}
+}
@@ -1,6 +1,8 @@
 package game
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,10 +10,17 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
-	Email        string    `json:"email" gorm:"unique"`
-	AuthProvider string    `json:"auth_provider"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	// Email is NULL for users of providers that do not share one (Steam).
+	Email        string         `json:"email" gorm:"unique;default:null"`
+	AuthProvider string         `json:"auth_provider"`
+	DisplayName  string         `json:"display_name"`
+	AvatarURL    string         `json:"avatar_url"`
+	AvatarPath   string         `json:"-"`        // uploaded avatar on disk, if any
+	Timezone     string         `json:"timezone"` // IANA name, e.g. "Europe/Stockholm"
+	Locale       string         `json:"locale"`   // BCP 47 tag, e.g. "sv-SE"
+	CreatedAt    time.Time      `json:"created_at"`
+	Identities   []UserIdentity `json:"identities,omitempty" gorm:"foreignKey:UserID"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
@@ -21,14 +30,79 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// normalizeEmail is the form emails are stored and looked up in. Accounts
+// created before emails were normalised are matched with LOWER(email).
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UserIdentity links a login method to a user. A user can sign in with any
+// of their identities.
+type UserIdentity struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `json:"user_id" gorm:"index"`
+	Provider  string    `json:"provider" gorm:"uniqueIndex:idx_identity_provider_subject"`
+	Subject   string    `json:"subject" gorm:"uniqueIndex:idx_identity_provider_subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (i *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return
+}
+
+// Join policies control who may join a game.
+const (
+	JoinPolicyOpen       = "open"        // anyone with the game link can join
+	JoinPolicyInviteOnly = "invite_only" // only invited emails can join
+	JoinPolicyApproval   = "approval"    // joins must be approved by the creator
+)
+
+// Game lifecycle states.
+const (
+	GameStatusLobby    = "lobby"    // gathering players, no saves yet
+	GameStatusActive   = "active"   // turns are being played
+	GameStatusPaused   = "paused"   // uploads are blocked until resumed
+	GameStatusFinished = "finished" // the game is over
+	GameStatusArchived = "archived" // hidden from game lists by default
+)
+
 type Game struct {
-	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
-	Name          string     `json:"name" gorm:"unique"`
-	CreatorID     uuid.UUID  `json:"creator_id"`
-	CurrentTurnID *uuid.UUID `json:"current_turn_id,omitempty"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	Players       []Player   `json:"players" gorm:"foreignKey:GameID"`
+	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	Name          string         `json:"name" gorm:"unique"`
+	CreatorID     uuid.UUID      `json:"creator_id"`
+	CurrentTurnID *uuid.UUID     `json:"current_turn_id,omitempty"`
+	TurnStartedAt *time.Time     `json:"turn_started_at,omitempty"` // nil while the clock is stopped
+	Round         int            `json:"round" gorm:"default:1"`    // current round of a simultaneous game
+	JoinPolicy    string         `json:"join_policy" gorm:"default:open"`
+	MaxPlayers    int            `json:"max_players"` // 0 means no limit
+	LobbyOpen     bool           `json:"lobby_open" gorm:"default:true"`
+	Status        string         `json:"status" gorm:"default:lobby;index"`
+	WinnerID      *uuid.UUID     `json:"winner_id,omitempty"` // winning player, if any
+	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
+	DeletedAt     gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+	Settings      GameSettings   `json:"settings" gorm:"embedded;embeddedPrefix:settings_"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	Players       []Player       `json:"players" gorm:"foreignKey:GameID"`
+}
+
+// GameSettings describes what is being played. Options holds settings that
+// only apply to one game type; see gameTypes for what each type accepts.
+type GameSettings struct {
+	GameType          string                 `json:"game_type"`
+	Description       string                 `json:"description"`
+	MapType           string                 `json:"map_type"`
+	Mods              []string               `json:"mods" gorm:"serializer:json"`
+	HouseRules        string                 `json:"house_rules"`
+	TurnMode          string                 `json:"turn_mode"`           // see TurnMode constants; empty means rotation
+	TurnDeadlineHours int                    `json:"turn_deadline_hours"` // 0 means no deadline
+	TimeBankHours     int                    `json:"time_bank_hours"`     // 0 means no chess clock
+	TimeBankPenalty   string                 `json:"time_bank_penalty"`   // see ClockPenalty constants
+	Options           map[string]interface{} `json:"options" gorm:"serializer:json"`
 }
 
 func (g *Game) BeforeCreate(tx *gorm.DB) (err error) {
@@ -38,12 +112,38 @@ func (g *Game) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// Player roles, from most to least privileged. The game's creator is always
+// treated as owner.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RolePlayer    = "player"
+	RoleSpectator = "spectator"
+)
+
+// Player is a user's membership in a game. Spectators are players outside
+// the turn rotation; their TurnOrder is unused.
 type Player struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
 	UserID    uuid.UUID `json:"user_id"`
 	User      User      `json:"user" gorm:"foreignKey:UserID"`
 	GameID    uuid.UUID `json:"game_id"`
 	TurnOrder int       `json:"turn_order"`
+	Role      string    `json:"role" gorm:"default:player"`
+	// How far the player has read the game's chat.
+	LastReadMessageID *uuid.UUID `json:"last_read_message_id,omitempty"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
+	// While away, the player's turns are skipped or go to their substitute.
+	AwayUntil        *time.Time `json:"away_until,omitempty"`
+	AwaySubstituteID *uuid.UUID `json:"away_substitute_id,omitempty"` // user covering for them
+	// Time spent holding the turn, for games with a chess clock.
+	TimeUsedSeconds int64      `json:"time_used_seconds"`
+	OutOfTimeAt     *time.Time `json:"out_of_time_at,omitempty"`
+}
+
+// isAway reports whether the player is on an away period at now.
+func (p Player) isAway(now time.Time) bool {
+	return p.AwayUntil != nil && p.AwayUntil.After(now)
 }
 
 func (p *Player) BeforeCreate(tx *gorm.DB) (err error) {
@@ -54,11 +154,15 @@ func (p *Player) BeforeCreate(tx *gorm.DB) (err error) {
 }
 
 type Save struct {
-	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
-	GameID     uuid.UUID `json:"game_id"`
-	FilePath   string    `json:"file_path"`
-	UploadedBy uuid.UUID `json:"uploaded_by"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	GameID     uuid.UUID  `json:"game_id"`
+	FilePath   string     `json:"file_path"`
+	UploadedBy uuid.UUID  `json:"uploaded_by"`
+	PlayerID   *uuid.UUID `json:"player_id,omitempty" gorm:"index"` // seat the save was uploaded from; follows substitutions
+	Uploader   User       `json:"-" gorm:"foreignKey:UploadedBy"`
+	Note       string     `json:"note"`                         // optional message from the uploader to the other players
+	Round      int        `json:"round,omitempty" gorm:"index"` // round of a simultaneous game the save was submitted for
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 func (s *Save) BeforeCreate(tx *gorm.DB) (err error) {
@@ -67,3 +171,177 @@ func (s *Save) BeforeCreate(tx *gorm.DB) (err error) {
 	}
 	return
 }
+
+// RefreshToken is a long lived credential used to obtain new access tokens.
+// Only a hash of the token is stored. Every refresh rotates the token; all
+// tokens descending from the same login share a FamilyID so that reuse of a
+// rotated token can revoke the whole chain.
+type RefreshToken struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"index"`
+	FamilyID  uuid.UUID  `json:"family_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (r *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return
+}
+
+// RevokedToken is a deny-list entry for an access token's jti. Entries are
+// kept until the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primary_key"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+}
+
+// LoginCode is a single-use code handed to the frontend in the login
+// redirect. The frontend exchanges it for tokens with POST /auth/token, so
+// no token ever appears in a URL. Only a hash of the code is stored.
+type LoginCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (l *LoginCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return
+}
+
+// MagicLink records an emailed login link. The link itself is a signed token
+// carrying this record's ID; UsedAt makes it single use.
+type MagicLink struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (m *MagicLink) BeforeCreate(tx *gorm.DB) (err error) {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return
+}
+
+// Invitation states.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationExpired  = "expired"
+)
+
+// Invitation asks someone, identified by email, to join a game.
+type Invitation struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	GameID      uuid.UUID  `json:"game_id" gorm:"index"`
+	Email       string     `json:"email" gorm:"index"`
+	InvitedBy   uuid.UUID  `json:"invited_by"`
+	Role        string     `json:"role" gorm:"default:player"`
+	Status      string     `json:"status" gorm:"default:pending"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (i *Invitation) BeforeCreate(tx *gorm.DB) (err error) {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return
+}
+
+// Join request states.
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+// JoinRequest is a user's request to join a game that needs the creator's
+// approval.
+type JoinRequest struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	GameID      uuid.UUID  `json:"game_id" gorm:"index"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"index"`
+	User        User       `json:"user" gorm:"foreignKey:UserID"`
+	Status      string     `json:"status" gorm:"default:pending"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (r *JoinRequest) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return
+}
+
+// ChatMessage is a message posted in a game's chat.
+type ChatMessage struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	GameID    uuid.UUID      `json:"game_id" gorm:"index:idx_chat_game_created"`
+	AuthorID  uuid.UUID      `json:"author_id" gorm:"index"`
+	Author    User           `json:"-" gorm:"foreignKey:AuthorID"`
+	Body      string         `json:"body"`
+	Mentions  []uuid.UUID    `json:"mentions,omitempty" gorm:"serializer:json"` // users mentioned with @name
+	Reactions []ChatReaction `json:"-" gorm:"foreignKey:MessageID"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	CreatedAt time.Time      `json:"created_at" gorm:"index:idx_chat_game_created"`
+}
+
+func (m *ChatMessage) BeforeCreate(tx *gorm.DB) (err error) {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return
+}
+
+// ChatReaction is one user's emoji reaction to a chat message.
+type ChatReaction struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	MessageID uuid.UUID `json:"message_id" gorm:"uniqueIndex:idx_reaction_message_user_emoji"`
+	UserID    uuid.UUID `json:"user_id" gorm:"uniqueIndex:idx_reaction_message_user_emoji"`
+	Emoji     string    `json:"emoji" gorm:"uniqueIndex:idx_reaction_message_user_emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *ChatReaction) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return
+}
+
+// GameEvent is an entry in a game's activity log. Data holds the payload
+// that was broadcast to SSE clients, so the event can be replayed as sent.
+type GameEvent struct {
+	ID        uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
+	GameID    uuid.UUID       `json:"game_id" gorm:"index:idx_event_game_created"`
+	ActorID   *uuid.UUID      `json:"actor_id,omitempty"` // nil for events nobody caused directly
+	Actor     *User           `json:"-" gorm:"foreignKey:ActorID"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data" gorm:"type:text"`
+	CreatedAt time.Time       `json:"created_at" gorm:"index:idx_event_game_created;index"`
+}
+
+func (e *GameEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return
+}
@@ -0,0 +1,458 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+var (
+	errAlreadyParticipant = fmt.Errorf("already a participant")
+	errGameFull           = fmt.Errorf("game is full")
+)
+
+// seated limits a player query to the turn rotation, leaving out spectators.
+func seated(db *gorm.DB) *gorm.DB {
+	return db.Where("role <> ?", RoleSpectator)
+}
+
+// addPlayerToGame seats a user with the given role at the end of the turn
+// order, respecting the game's player limit. Spectators take no seat and do
+// not count towards the limit.
+func addPlayerToGame(tx *gorm.DB, gameID, userID uuid.UUID, role string) (Player, error) {
+	var existingPlayer Player
+	if err := tx.Where("user_id = ? AND game_id = ?", userID, gameID).First(&existingPlayer).Error; err == nil {
+		return existingPlayer, errAlreadyParticipant
+	} else if err != gorm.ErrRecordNotFound {
+		return Player{}, err
+	}
+
+	if role == RoleSpectator {
+		player := Player{UserID: userID, GameID: gameID, Role: role}
+		if err := tx.Create(&player).Error; err != nil {
+			return Player{}, err
+		}
+		return player, nil
+	}
+
+	var game Game
+	if err := tx.First(&game, "id = ?", gameID).Error; err != nil {
+		return Player{}, err
+	}
+	if game.MaxPlayers > 0 {
+		var count int64
+		if err := tx.Model(&Player{}).Scopes(seated).Where("game_id = ?", gameID).Count(&count).Error; err != nil {
+			return Player{}, err
+		}
+		if count >= int64(game.MaxPlayers) {
+			return Player{}, errGameFull
+		}
+	}
+
+	var maxTurnOrder int
+	if err := tx.Model(&Player{}).Scopes(seated).Where("game_id = ?", gameID).Select("COALESCE(MAX(turn_order), -1)").Scan(&maxTurnOrder).Error; err != nil {
+		return Player{}, fmt.Errorf("failed to determine turn order: %w", err)
+	}
+
+	player := Player{
+		UserID:    userID,
+		GameID:    gameID,
+		TurnOrder: maxTurnOrder + 1,
+		Role:      role,
+	}
+	if err := tx.Create(&player).Error; err != nil {
+		return Player{}, err
+	}
+	return player, nil
+}
+
+// broadcastPlayerJoined tells everyone watching the game that a player joined.
+func broadcastPlayerJoined(db *gorm.DB, sseManager sse.Broadcaster, player Player, actorID uuid.UUID) {
+	publishEvent(db, sseManager, player.GameID, actorID, "player_joined", map[string]interface{}{
+		"game_id":    player.GameID.String(),
+		"player_id":  player.ID.String(),
+		"user_id":    player.UserID.String(),
+		"turn_order": player.TurnOrder,
+		"role":       player.Role,
+	})
+}
+
+// removePlayer deletes a seat and closes the gap it leaves in the turn order.
+// If the player held the turn, it passes to whoever was seated after them.
+func removePlayer(tx *gorm.DB, game Game, player Player) error {
+	// Nobody can cover turns in a game they have left.
+	if err := tx.Model(&Player{}).Where("game_id = ? AND away_substitute_id = ?", game.ID, player.UserID).
+		Update("away_substitute_id", nil).Error; err != nil {
+		return err
+	}
+	if player.Role == RoleSpectator {
+		return tx.Delete(&Player{}, "id = ?", player.ID).Error
+	}
+
+	var players []Player
+	if err := tx.Scopes(seated).Where("game_id = ?", game.ID).Order("turn_order ASC").Find(&players).Error; err != nil {
+		return err
+	}
+
+	remaining := make([]Player, 0, len(players))
+	nextTurn := -1
+	for i, p := range players {
+		if p.ID == player.ID {
+			nextTurn = i
+			continue
+		}
+		remaining = append(remaining, p)
+	}
+
+	if game.CurrentTurnID != nil && *game.CurrentTurnID == player.ID {
+		var next *uuid.UUID
+		if len(remaining) > 0 {
+			next = &remaining[nextTurn%len(remaining)].ID
+		}
+		// The clock restarts for whoever inherits the turn.
+		var startedAt *time.Time
+		if next != nil && game.Status == GameStatusActive {
+			now := time.Now()
+			startedAt = &now
+		}
+		if err := tx.Model(&Game{}).Where("id = ?", game.ID).Updates(map[string]interface{}{
+			"current_turn_id": next,
+			"turn_started_at": startedAt,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Delete(&Player{}, "id = ?", player.ID).Error; err != nil {
+		return err
+	}
+	return compactTurnOrder(tx, game.ID)
+}
+
+// compactTurnOrder renumbers a game's seats 0, 1, 2, ... keeping their order.
+func compactTurnOrder(tx *gorm.DB, gameID uuid.UUID) error {
+	var players []Player
+	if err := tx.Scopes(seated).Where("game_id = ?", gameID).Order("turn_order ASC").Find(&players).Error; err != nil {
+		return err
+	}
+	for i, p := range players {
+		if p.TurnOrder == i {
+			continue
+		}
+		if err := tx.Model(&Player{}).Where("id = ?", p.ID).Update("turn_order", i).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyPlayerLeft tells the remaining players that someone left or was
+// removed.
+func notifyPlayerLeft(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, game Game, player Player, reason string, actorID uuid.UUID) {
+	publishEvent(db, sseManager, game.ID, actorID, "player_left", map[string]interface{}{
+		"game_id":   game.ID.String(),
+		"player_id": player.ID.String(),
+		"user_id":   player.UserID.String(),
+		"reason":    reason,
+	})
+
+	var players []Player
+	if err := db.Preload("User").Where("game_id = ?", game.ID).Find(&players).Error; err != nil {
+		fmt.Printf("Warning: failed to get players for notification: %v\n", err)
+		return
+	}
+	subject := fmt.Sprintf("A player %s %s", reason, game.Name)
+	body := fmt.Sprintf("%s %s %s. The turn order has been updated.", displayName(player.User), reason, game.Name)
+	for _, p := range players {
+		if p.User.Email == "" || p.isAway(time.Now()) {
+			continue
+		}
+		if err := notifier.Notify(p.User.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", p.User.Email, err)
+		}
+	}
+}
+
+// LeaveGameHandler removes the current user from a game. The owner cannot
+// leave; they must transfer ownership or delete the game first.
+func LeaveGameHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		gameID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+
+		var game Game
+		if err := db.First(&game, "id = ?", gameID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+		if game.CreatorID == userID {
+			c.JSON(http.StatusConflict, gin.H{"error": "the owner must transfer ownership before leaving"})
+			return
+		}
+
+		var player Player
+		if err := db.Preload("User").Where("user_id = ? AND game_id = ?", userID, gameID).First(&player).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not a member of this game"})
+			return
+		}
+
+		covered, err := coveredBy(db, game.ID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return removePlayer(tx, game, player)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave game"})
+			return
+		}
+		notifyPlayerLeft(db, sseManager, notifier, game, player, "left", actorOf(c))
+		passUncoveredTurn(db, sseManager, notifier, game.ID, covered)
+		completeRound(db, sseManager, notifier, game, actorOf(c))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Left game"})
+	}
+}
+
+// KickPlayerHandler lets the owner or an admin remove someone with a lower
+// role from a game.
+func KickPlayerHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		playerID, err := uuid.Parse(c.Param("playerId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+
+		var player Player
+		if err := db.Preload("User").Where("id = ? AND game_id = ?", playerID, game.ID).First(&player).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+		userID, _ := getUserIDFromContext(c)
+		actorRole, err := roleOf(db, game, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		targetRole, err := roleOf(db, game, player.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if roleRank[targetRole] >= roleRank[actorRole] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only remove members with a lower role"})
+			return
+		}
+
+		covered, err := coveredBy(db, game.ID, player.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return removePlayer(tx, game, player)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove player"})
+			return
+		}
+		notifyPlayerLeft(db, sseManager, notifier, game, player, "was removed from", actorOf(c))
+		passUncoveredTurn(db, sseManager, notifier, game.ID, covered)
+		completeRound(db, sseManager, notifier, game, actorOf(c))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Player removed"})
+	}
+}
+
+type TurnOrderRequest struct {
+	PlayerIDs []uuid.UUID `json:"player_ids" binding:"required,min=1"`
+}
+
+type SubstituteRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ReorderPlayersHandler lets the owner or an admin set a new turn order. The request
+// must list every player in the game exactly once; spectators are not part of
+// the order. Whoever holds the turn keeps it.
+func ReorderPlayersHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		var req TurnOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "player_ids is required"})
+			return
+		}
+
+		var players []Player
+		if err := db.Scopes(seated).Where("game_id = ?", game.ID).Find(&players).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve players"})
+			return
+		}
+
+		inRotation := make(map[uuid.UUID]bool, len(players))
+		for _, p := range players {
+			inRotation[p.ID] = true
+		}
+		listed := make(map[uuid.UUID]bool, len(req.PlayerIDs))
+		for _, id := range req.PlayerIDs {
+			if !inRotation[id] || listed[id] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "player_ids must list every player in the game exactly once"})
+				return
+			}
+			listed[id] = true
+		}
+		if len(listed) != len(players) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "player_ids must list every player in the game exactly once"})
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			for i, id := range req.PlayerIDs {
+				if err := tx.Model(&Player{}).Where("id = ?", id).Update("turn_order", i).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update turn order"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "turn_order_changed", map[string]interface{}{
+			"game_id":    game.ID.String(),
+			"player_ids": req.PlayerIDs,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Turn order updated", "player_ids": req.PlayerIDs})
+	}
+}
+
+// SubstitutePlayerHandler hands a seat to another user. The seat keeps its
+// turn position, its turn if it holds one, and its save history. The new user
+// gets the seat as a plain player, and seats the previous user was covering
+// lose their substitute.
+func SubstitutePlayerHandler(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		playerID, err := uuid.Parse(c.Param("playerId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+
+		var player Player
+		if err := db.Where("id = ? AND game_id = ?", playerID, game.ID).First(&player).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+
+		if player.UserID == game.CreatorID {
+			c.JSON(http.StatusConflict, gin.H{"error": "transfer ownership before handing over the owner's seat"})
+			return
+		}
+
+		var req SubstituteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a valid email is required"})
+			return
+		}
+
+		var substitute User
+		if err := db.Where("LOWER(email) = ?", normalizeEmail(req.Email)).First(&substitute).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no user with that email; ask them to sign in first"})
+			return
+		}
+
+		var existing int64
+		if err := db.Model(&Player{}).Where("game_id = ? AND user_id = ?", game.ID, substitute.ID).Count(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "already a participant"})
+			return
+		}
+
+		previousUserID := player.UserID
+		covered, err := coveredBy(db, game.ID, previousUserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// The newcomer starts afresh: none of the previous user's rights,
+			// away period or chat position carry over.
+			role := player.Role
+			if role != RoleSpectator {
+				role = RolePlayer
+			}
+			if err := tx.Model(&player).Updates(map[string]interface{}{
+				"user_id":              substitute.ID,
+				"role":                 role,
+				"away_until":           nil,
+				"away_substitute_id":   nil,
+				"last_read_message_id": nil,
+				"last_read_at":         nil,
+			}).Error; err != nil {
+				return err
+			}
+			// Nobody can cover turns in a game they have left.
+			return tx.Model(&Player{}).Where("game_id = ? AND away_substitute_id = ?", game.ID, previousUserID).
+				Update("away_substitute_id", nil).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to substitute player"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "player_substituted", map[string]interface{}{
+			"game_id":          game.ID.String(),
+			"player_id":        player.ID.String(),
+			"previous_user_id": previousUserID.String(),
+			"user_id":          substitute.ID.String(),
+		})
+		passUncoveredTurn(db, sseManager, notifier, game.ID, covered)
+		completeRound(db, sseManager, notifier, game, actorOf(c))
+
+		subject := fmt.Sprintf("You've taken over a seat in %s", game.Name)
+		body := fmt.Sprintf("You are now playing seat %d in %s.", player.TurnOrder+1, game.Name)
+		if game.CurrentTurnID != nil && *game.CurrentTurnID == player.ID {
+			body += " It's your turn!"
+		}
+		if err := notifier.Notify(substitute.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", substitute.Email, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Player substituted", "player_id": player.ID, "user_id": substitute.ID})
+	}
+}
@@ -0,0 +1,299 @@
+package game
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxAvatarSize        = 2 * 1024 * 1024 // 2MB
+	avatarDir            = "avatars"
+)
+
+var (
+	localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+	// avatarTypes maps the image types accepted for avatars to their extension.
+	avatarTypes = map[string]string{
+		"image/png":  ".png",
+		"image/jpeg": ".jpg",
+		"image/gif":  ".gif",
+		"image/webp": ".webp",
+	}
+)
+
+// ProfileUpdateRequest changes the fields that are set; an empty string
+// clears a field.
+type ProfileUpdateRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Timezone    *string `json:"timezone"`
+	Locale      *string `json:"locale"`
+}
+
+// applyProviderProfile fills in profile fields the user has not set yet from
+// what the provider shared. Anything the user already has is left alone.
+func applyProviderProfile(tx *gorm.DB, user *User, identity ProviderIdentity) error {
+	updates := map[string]interface{}{}
+	if user.DisplayName == "" && identity.Name != "" {
+		user.DisplayName = identity.Name
+		updates["display_name"] = identity.Name
+	}
+	if user.AvatarURL == "" && identity.AvatarURL != "" {
+		user.AvatarURL = identity.AvatarURL
+		updates["avatar_url"] = identity.AvatarURL
+	}
+	if user.Locale == "" && localePattern.MatchString(identity.Locale) {
+		user.Locale = identity.Locale
+		updates["locale"] = identity.Locale
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return tx.Model(user).Updates(updates).Error
+}
+
+// validate checks the request and returns the column updates it makes.
+func (r ProfileUpdateRequest) validate() (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	if r.DisplayName != nil {
+		name := strings.TrimSpace(*r.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return nil, fmt.Errorf("display_name must be at most %d characters", maxDisplayNameLength)
+		}
+		updates["display_name"] = name
+	}
+	if r.AvatarURL != nil {
+		avatar := strings.TrimSpace(*r.AvatarURL)
+		if avatar != "" && !strings.HasPrefix(avatar, "https://") && !strings.HasPrefix(avatar, "http://") {
+			return nil, fmt.Errorf("avatar_url must be an http(s) URL")
+		}
+		updates["avatar_url"] = avatar
+		updates["avatar_path"] = ""
+	}
+	if r.Timezone != nil {
+		if *r.Timezone != "" {
+			if _, err := time.LoadLocation(*r.Timezone); err != nil || *r.Timezone == "Local" {
+				return nil, fmt.Errorf("unknown timezone %q", *r.Timezone)
+			}
+		}
+		updates["timezone"] = *r.Timezone
+	}
+	if r.Locale != nil {
+		if *r.Locale != "" && !localePattern.MatchString(*r.Locale) {
+			return nil, fmt.Errorf("invalid locale %q", *r.Locale)
+		}
+		updates["locale"] = *r.Locale
+	}
+	return updates, nil
+}
+
+// localDate formats t as a day in the user's timezone, month first for
+// American English and day first for other locales. Without a timezone the
+// date is given in UTC.
+func localDate(user User, t time.Time) string {
+	location := time.UTC
+	if user.Timezone != "" {
+		if loc, err := time.LoadLocation(user.Timezone); err == nil {
+			location = loc
+		}
+	}
+	layout := "2 January"
+	switch strings.ToLower(user.Locale) {
+	case "", "en", "en-us":
+		layout = "January 2"
+	}
+	return t.In(location).Format(layout)
+}
+
+// removeUploadedAvatar deletes the user's uploaded avatar file, if any.
+func removeUploadedAvatar(user User) {
+	if user.AvatarPath == "" {
+		return
+	}
+	if err := os.Remove(user.AvatarPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to delete avatar %s: %v\n", user.AvatarPath, err)
+	}
+}
+
+// GetProfileHandler returns the current user's profile.
+func GetProfileHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UpdateProfileHandler edits the current user's display name, avatar URL,
+// timezone and locale.
+func UpdateProfileHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var req ProfileUpdateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile"})
+			return
+		}
+		updates, err := req.validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		previous := user
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+				return
+			}
+		}
+		if req.AvatarURL != nil {
+			removeUploadedAvatar(previous)
+		}
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UploadAvatarHandler stores an uploaded image as the current user's avatar
+// and points their avatar URL at it.
+func UploadAvatarHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed"})
+			return
+		}
+		defer file.Close()
+
+		if header.Size > maxAvatarSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+			return
+		}
+
+		mimeType, err := detectMimeType(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file type"})
+			return
+		}
+		ext, ok := avatarTypes[mimeType]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be a PNG, JPEG, GIF or WebP image"})
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process file"})
+			return
+		}
+
+		if err := os.MkdirAll(avatarDir, 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create avatar directory"})
+			return
+		}
+		// A fresh name per upload keeps cached copies of the old avatar from
+		// being served for the new one.
+		filePath := filepath.Join(avatarDir, fmt.Sprintf("%s_%s%s", userID, uuid.New().String(), ext))
+		outFile, err := os.Create(filePath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create file"})
+			return
+		}
+		defer outFile.Close()
+		if _, err := io.Copy(outFile, io.LimitReader(file, maxAvatarSize)); err != nil {
+			os.Remove(filePath)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return
+		}
+
+		previous := user
+		avatarURL := fmt.Sprintf("%s/users/%s/avatar?v=%d", cfg.BackendUrl, userID, time.Now().Unix())
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"avatar_url":  avatarURL,
+			"avatar_path": filePath,
+		}).Error; err != nil {
+			os.Remove(filePath)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+		removeUploadedAvatar(previous)
+
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// GetAvatarHandler serves a user's uploaded avatar. Avatars are public so
+// that they can be used in emails and <img> tags.
+func GetAvatarHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil || user.AvatarPath == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+		if !isPathSafe(filepath.Clean(user.AvatarPath), avatarDir) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.File(user.AvatarPath)
+	}
+}
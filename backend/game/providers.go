@@ -0,0 +1,388 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+// ProviderIdentity is what an identity provider tells us about a user.
+type ProviderIdentity struct {
+	Provider      string
+	Subject       string // the provider's stable user ID
+	Email         string
+	EmailVerified bool
+	Name          string
+	AvatarURL     string
+	Locale        string
+}
+
+// Provider is an external identity provider users can log in with.
+type Provider interface {
+	Name() string
+	// LoginURL returns where to send the browser to start a login. state is
+	// echoed back to the callback and checked against the state cookie.
+	LoginURL(state string) string
+	// Identify completes the login from the callback request's query.
+	Identify(ctx context.Context, query url.Values) (ProviderIdentity, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// RegisterProvider makes a provider available under /auth/<name>/...,
+// replacing any provider registered under the same name.
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// OAuth2Provider logs users in with the authorization code flow and reads
+// their profile from a JSON user info endpoint.
+type OAuth2Provider struct {
+	ProviderName string
+	Config       *oauth2.Config
+	UserInfoURL  string
+	// EmailsURL is consulted when the user info has no usable email (GitHub
+	// hides private addresses from /user).
+	EmailsURL     string
+	ParseUserInfo func(body []byte) (ProviderIdentity, error)
+}
+
+func (p *OAuth2Provider) Name() string { return p.ProviderName }
+
+func (p *OAuth2Provider) LoginURL(state string) string {
+	return p.Config.AuthCodeURL(state)
+}
+
+func (p *OAuth2Provider) Identify(ctx context.Context, query url.Values) (ProviderIdentity, error) {
+	token, err := p.Config.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("code exchange failed: %w", err)
+	}
+	client := p.Config.Client(ctx, token)
+
+	contents, err := fetchJSON(client, p.UserInfoURL)
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("failed to get user info: %w", err)
+	}
+	fmt.Printf("Raw %s User Info Response: %s\n", p.ProviderName, contents)
+
+	identity, err := p.ParseUserInfo(contents)
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("failed to parse user info: %w", err)
+	}
+	identity.Provider = p.ProviderName
+
+	if (identity.Email == "" || !identity.EmailVerified) && p.EmailsURL != "" {
+		if contents, err := fetchJSON(client, p.EmailsURL); err == nil {
+			var emails []struct {
+				Email    string `json:"email"`
+				Primary  bool   `json:"primary"`
+				Verified bool   `json:"verified"`
+			}
+			if json.Unmarshal(contents, &emails) == nil {
+				for _, e := range emails {
+					if e.Primary && e.Verified {
+						identity.Email = e.Email
+						identity.EmailVerified = true
+					}
+				}
+			}
+		}
+	}
+
+	if identity.Subject == "" {
+		return ProviderIdentity{}, fmt.Errorf("user ID not provided by %s", p.ProviderName)
+	}
+	return identity, nil
+}
+
+func fetchJSON(client *http.Client, endpoint string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return fetchJSONRequest(client, req)
+}
+
+func NewGoogleProvider(cfg config.Config) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "google",
+		Config: &oauth2.Config{
+			ClientID:     cfg.GoogleOauthClientID,
+			ClientSecret: cfg.GoogleOauthClientSecret,
+			RedirectURL:  cfg.GoogleOauthRedirectUrl,
+			Scopes:       []string{"email", "profile"},
+			Endpoint:     google.Endpoint,
+		},
+		UserInfoURL: "https://www.googleapis.com/oauth2/v2/userinfo",
+		ParseUserInfo: func(body []byte) (ProviderIdentity, error) {
+			var info struct {
+				ID            string `json:"id"`
+				Email         string `json:"email"`
+				VerifiedEmail *bool  `json:"verified_email"`
+				Name          string `json:"name"`
+				Picture       string `json:"picture"`
+				Locale        string `json:"locale"`
+			}
+			if err := json.Unmarshal(body, &info); err != nil {
+				return ProviderIdentity{}, err
+			}
+			return ProviderIdentity{
+				Subject:       info.ID,
+				Email:         info.Email,
+				EmailVerified: info.VerifiedEmail == nil || *info.VerifiedEmail,
+				Name:          info.Name,
+				AvatarURL:     info.Picture,
+				Locale:        info.Locale,
+			}, nil
+		},
+	}
+}
+
+func NewDiscordProvider(cfg config.Config) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "discord",
+		Config: &oauth2.Config{
+			ClientID:     cfg.DiscordOauthClientID,
+			ClientSecret: cfg.DiscordOauthClientSecret,
+			RedirectURL:  cfg.DiscordOauthRedirectUrl,
+			Scopes:       []string{"identify", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://discord.com/oauth2/authorize",
+				TokenURL:  "https://discord.com/api/oauth2/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		UserInfoURL: "https://discord.com/api/users/@me",
+		ParseUserInfo: func(body []byte) (ProviderIdentity, error) {
+			var info struct {
+				ID         string `json:"id"`
+				Username   string `json:"username"`
+				GlobalName string `json:"global_name"`
+				Email      string `json:"email"`
+				Verified   bool   `json:"verified"`
+				Avatar     string `json:"avatar"`
+				Locale     string `json:"locale"`
+			}
+			if err := json.Unmarshal(body, &info); err != nil {
+				return ProviderIdentity{}, err
+			}
+			identity := ProviderIdentity{
+				Subject:       info.ID,
+				Email:         info.Email,
+				EmailVerified: info.Verified,
+				Name:          info.GlobalName,
+				Locale:        info.Locale,
+			}
+			if identity.Name == "" {
+				identity.Name = info.Username
+			}
+			if info.Avatar != "" {
+				identity.AvatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", info.ID, info.Avatar)
+			}
+			return identity, nil
+		},
+	}
+}
+
+func NewGitHubProvider(cfg config.Config) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "github",
+		Config: &oauth2.Config{
+			ClientID:     cfg.GithubOauthClientID,
+			ClientSecret: cfg.GithubOauthClientSecret,
+			RedirectURL:  cfg.GithubOauthRedirectUrl,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     github.Endpoint,
+		},
+		UserInfoURL: "https://api.github.com/user",
+		EmailsURL:   "https://api.github.com/user/emails",
+		ParseUserInfo: func(body []byte) (ProviderIdentity, error) {
+			var info struct {
+				ID        json.Number `json:"id"`
+				Login     string      `json:"login"`
+				Name      string      `json:"name"`
+				AvatarURL string      `json:"avatar_url"`
+			}
+			if err := json.Unmarshal(body, &info); err != nil {
+				return ProviderIdentity{}, err
+			}
+			identity := ProviderIdentity{
+				Subject:   info.ID.String(),
+				Name:      info.Name,
+				AvatarURL: info.AvatarURL,
+			}
+			if identity.Name == "" {
+				identity.Name = info.Login
+			}
+			// The public profile email is not necessarily verified; the
+			// emails endpoint is used instead.
+			return identity, nil
+		},
+	}
+}
+
+const steamClaimedIDPrefix = "https://steamcommunity.com/openid/id/"
+
+// SteamProvider logs users in with Steam's OpenID 2.0 endpoint. Steam does not
+// share email addresses, so Steam users are identified by their SteamID only.
+type SteamProvider struct {
+	OpenIDEndpoint string
+	ReturnURL      string
+	Realm          string
+	// SummaryURL and APIKey are used to look up the display name and avatar.
+	// Both are optional.
+	SummaryURL string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func NewSteamProvider(cfg config.Config) *SteamProvider {
+	realm := cfg.SteamRealm
+	if realm == "" {
+		if u, err := url.Parse(cfg.SteamReturnUrl); err == nil {
+			realm = u.Scheme + "://" + u.Host
+		}
+	}
+	return &SteamProvider{
+		OpenIDEndpoint: "https://steamcommunity.com/openid/login",
+		ReturnURL:      cfg.SteamReturnUrl,
+		Realm:          realm,
+		SummaryURL:     "https://api.steampowered.com/ISteamUser/GetPlayerSummaries/v0002/",
+		APIKey:         cfg.SteamAPIKey,
+		HTTPClient:     http.DefaultClient,
+	}
+}
+
+func (p *SteamProvider) Name() string { return "steam" }
+
+func (p *SteamProvider) returnTo(state string) string {
+	sep := "?"
+	if strings.Contains(p.ReturnURL, "?") {
+		sep = "&"
+	}
+	return p.ReturnURL + sep + "state=" + url.QueryEscape(state)
+}
+
+func (p *SteamProvider) LoginURL(state string) string {
+	params := url.Values{}
+	params.Set("openid.ns", "http://specs.openid.net/auth/2.0")
+	params.Set("openid.mode", "checkid_setup")
+	params.Set("openid.return_to", p.returnTo(state))
+	params.Set("openid.realm", p.Realm)
+	params.Set("openid.identity", "http://specs.openid.net/auth/2.0/identifier_select")
+	params.Set("openid.claimed_id", "http://specs.openid.net/auth/2.0/identifier_select")
+	return p.OpenIDEndpoint + "?" + params.Encode()
+}
+
+func (p *SteamProvider) Identify(ctx context.Context, query url.Values) (ProviderIdentity, error) {
+	if query.Get("openid.mode") != "id_res" {
+		return ProviderIdentity{}, fmt.Errorf("steam login was not completed")
+	}
+	if !strings.HasPrefix(query.Get("openid.return_to"), p.ReturnURL) {
+		return ProviderIdentity{}, fmt.Errorf("unexpected openid.return_to")
+	}
+	claimedID := query.Get("openid.claimed_id")
+	steamID := strings.TrimPrefix(claimedID, steamClaimedIDPrefix)
+	if steamID == claimedID || steamID == "" {
+		return ProviderIdentity{}, fmt.Errorf("unexpected openid.claimed_id")
+	}
+
+	// Ask Steam to confirm the signed assertion.
+	check := url.Values{}
+	for key, values := range query {
+		if strings.HasPrefix(key, "openid.") {
+			check[key] = values
+		}
+	}
+	check.Set("openid.mode", "check_authentication")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.OpenIDEndpoint, strings.NewReader(check.Encode()))
+	if err != nil {
+		return ProviderIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("failed to verify steam login: %w", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("failed to verify steam login: %w", err)
+	}
+	if !strings.Contains(string(body), "is_valid:true") {
+		return ProviderIdentity{}, fmt.Errorf("steam rejected the login assertion")
+	}
+
+	identity := ProviderIdentity{Provider: p.Name(), Subject: steamID}
+	if p.APIKey != "" && p.SummaryURL != "" {
+		p.fillProfile(ctx, &identity)
+	}
+	return identity, nil
+}
+
+// fillProfile adds the persona name and avatar. Failures are not fatal.
+func (p *SteamProvider) fillProfile(ctx context.Context, identity *ProviderIdentity) {
+	params := url.Values{}
+	params.Set("key", p.APIKey)
+	params.Set("steamids", identity.Subject)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.SummaryURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return
+	}
+	contents, err := fetchJSONRequest(p.HTTPClient, req)
+	if err != nil {
+		fmt.Printf("Warning: failed to get steam profile for %s: %v\n", identity.Subject, err)
+		return
+	}
+	var summary struct {
+		Response struct {
+			Players []struct {
+				PersonaName string `json:"personaname"`
+				AvatarFull  string `json:"avatarfull"`
+			} `json:"players"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(contents, &summary); err != nil || len(summary.Response.Players) == 0 {
+		return
+	}
+	identity.Name = summary.Response.Players[0].PersonaName
+	identity.AvatarURL = summary.Response.Players[0].AvatarFull
+}
+
+func fetchJSONRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
@@ -0,0 +1,137 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+// RestoreGameHandler undoes a deletion while the game is still within its
+// grace period.
+func RestoreGameHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		gameID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+
+		var game Game
+		if err := db.Unscoped().First(&game, "id = ?", gameID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+		role, err := roleOf(db, game, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if !hasPermission(role, PermissionOwnGame) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can restore this game"})
+			return
+		}
+		if !game.DeletedAt.Valid {
+			c.JSON(http.StatusConflict, gin.H{"error": "game is not deleted"})
+			return
+		}
+		if time.Since(game.DeletedAt.Time) > cfg.DeletedGameGracePeriod {
+			c.JSON(http.StatusGone, gin.H{"error": "game can no longer be restored"})
+			return
+		}
+
+		if err := db.Unscoped().Model(&game).Update("deleted_at", nil).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore game"})
+			return
+		}
+		recordEvent(db, game.ID, userID, "game_restored", map[string]interface{}{
+			"game_id": game.ID.String(),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "game restored", "game_id": game.ID})
+	}
+}
+
+// PurgeDeletedGames permanently removes games deleted more than grace ago,
+// together with their rows and save files. Files are only removed once the
+// database transaction has committed, so a failed purge never leaves rows
+// pointing at missing files.
+func PurgeDeletedGames(db *gorm.DB, grace time.Duration) (int, error) {
+	var games []Game
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-grace)).
+		Find(&games).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, game := range games {
+		var saves []Save
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("game_id = ?", game.ID).Find(&saves).Error; err != nil {
+				return err
+			}
+			messages := tx.Unscoped().Model(&ChatMessage{}).Select("id").Where("game_id = ?", game.ID)
+			if err := tx.Where("message_id IN (?)", messages).Delete(&ChatReaction{}).Error; err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&Save{}, &Player{}, &Invitation{}, &JoinRequest{}, &ChatMessage{}, &GameEvent{}} {
+				if err := tx.Unscoped().Where("game_id = ?", game.ID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Unscoped().Delete(&Game{}, "id = ?", game.ID).Error
+		})
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge game %s: %w", game.ID, err)
+		}
+		purged++
+
+		for _, save := range saves {
+			if err := os.Remove(save.FilePath); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Warning: failed to delete save file %s: %v\n", save.FilePath, err)
+			}
+		}
+		// Remove the game's save directory if nothing else is left in it.
+		os.Remove(filepath.Join("saves", game.ID.String()))
+	}
+	return purged, nil
+}
+
+// StartPurgeJob runs PurgeDeletedGames every interval until stop is called.
+// An interval of zero or less disables the job.
+func StartPurgeJob(db *gorm.DB, interval, grace time.Duration) (stop func()) {
+	if interval <= 0 {
+		fmt.Printf("Warning: purge job disabled, PURGE_INTERVAL is %v\n", interval)
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := PurgeDeletedGames(db, grace); err != nil {
+					fmt.Printf("Warning: purge of deleted games failed: %v\n", err)
+				} else if n > 0 {
+					fmt.Printf("Purged %d deleted games\n", n)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,157 @@
+package game
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter is a token bucket limiter keyed per client. Every key gets its
+// own bucket holding up to limit tokens, refilled evenly over window. Buckets
+// that have not been touched for idleExpiry are dropped on a later request,
+// so the limiter needs no background goroutine.
+type RateLimiter struct {
+	limit      int
+	window     time.Duration
+	idleExpiry time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimitResult describes the state of a bucket after a request was counted.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // time until the next token is available
+	Reset      time.Duration // time until the bucket is full again
+}
+
+func NewRateLimiter(limit int, window, idleExpiry time.Duration) *RateLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	// A bucket must never be forgotten before it could have refilled,
+	// otherwise dropping it would hand the client a fresh allowance.
+	if idleExpiry < window {
+		idleExpiry = window
+	}
+	return &RateLimiter{
+		limit:      limit,
+		window:     window,
+		idleExpiry: idleExpiry,
+		buckets:    make(map[string]*rateBucket),
+		now:        time.Now,
+	}
+}
+
+// Allow counts one request for key and reports whether it may proceed.
+func (rl *RateLimiter) Allow(key string) RateLimitResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	refillRate := float64(rl.limit) / rl.window.Seconds() // tokens per second
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(rl.limit), lastSeen: now}
+		rl.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.lastSeen).Seconds()
+		b.tokens = math.Min(float64(rl.limit), b.tokens+elapsed*refillRate)
+		b.lastSeen = now
+	}
+
+	result := RateLimitResult{Limit: rl.limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / refillRate)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = secondsToDuration((float64(rl.limit) - b.tokens) / refillRate)
+	return result
+}
+
+// Len returns the number of buckets currently tracked.
+func (rl *RateLimiter) Len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+// sweep drops idle buckets. It runs at most once per idleExpiry and must be
+// called with rl.mu held.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.idleExpiry {
+		return
+	}
+	for key, b := range rl.buckets {
+		if now.Sub(b.lastSeen) >= rl.idleExpiry {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// SetClock replaces the limiter's time source. It exists for tests.
+func (rl *RateLimiter) SetClock(now func() time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.now = now
+}
+
+// Middleware limits requests per authenticated user, falling back to the
+// client IP when the route is not behind AuthMiddleware.
+func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userID, err := getUserIDFromContext(c); err == nil {
+			key = "user:" + userID.String()
+		}
+
+		result := rl.Allow(key)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimitMiddleware allows maxRequests per duration for each user or client IP.
+func RateLimitMiddleware(maxRequests int, duration, idleExpiry time.Duration) gin.HandlerFunc {
+	return NewRateLimiter(maxRequests, duration, idleExpiry).Middleware()
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// maxEmojiLength allows for skin tones, flags and joined sequences such as
+// family emoji.
+const maxEmojiLength = 8
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// isEmoji reports whether s looks like a single emoji rather than text.
+func isEmoji(s string) bool {
+	if s == "" || !utf8.ValidString(s) || utf8.RuneCountInString(s) > maxEmojiLength {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func reactionEvent(message ChatMessage, reaction ChatReaction) map[string]interface{} {
+	return map[string]interface{}{
+		"game_id":    message.GameID.String(),
+		"message_id": message.ID.String(),
+		"user_id":    reaction.UserID.String(),
+		"emoji":      reaction.Emoji,
+	}
+}
+
+// AddReactionHandler reacts to a chat message with an emoji. Reacting twice
+// with the same emoji has no further effect.
+func AddReactionHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		message, ok := loadGameMessage(c, db, game)
+		if !ok {
+			return
+		}
+
+		var req ReactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "emoji is required"})
+			return
+		}
+		emoji := strings.TrimSpace(req.Emoji)
+		if !isEmoji(emoji) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "emoji must be a single emoji"})
+			return
+		}
+
+		reaction := ChatReaction{MessageID: message.ID, UserID: userID, Emoji: emoji}
+		result := db.Where(ChatReaction{MessageID: message.ID, UserID: userID, Emoji: emoji}).FirstOrCreate(&reaction)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusOK, reaction)
+			return
+		}
+
+		sendToMembers(db, sseManager, game.ID, "", "chat_reaction_added", reactionEvent(message, reaction))
+		c.JSON(http.StatusCreated, reaction)
+	}
+}
+
+// RemoveReactionHandler takes back the current user's :emoji reaction to a
+// chat message.
+func RemoveReactionHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, userID, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+		message, ok := loadGameMessage(c, db, game)
+		if !ok {
+			return
+		}
+
+		reaction := ChatReaction{MessageID: message.ID, UserID: userID, Emoji: c.Param("emoji")}
+		result := db.Where("message_id = ? AND user_id = ? AND emoji = ?", reaction.MessageID, reaction.UserID, reaction.Emoji).
+			Delete(&ChatReaction{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reaction not found"})
+			return
+		}
+
+		sendToMembers(db, sseManager, game.ID, "", "chat_reaction_removed", reactionEvent(message, reaction))
+		c.JSON(http.StatusOK, gin.H{"message": "Reaction removed"})
+	}
+}
@@ -0,0 +1,253 @@
+package game
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Response types shape what the API returns, so that adding a column to a
+// model never exposes it by accident.
+
+// UserResponse is how a user appears to other users. Email is only filled in
+// for people who share a game with them.
+type UserResponse struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+	Email       string    `json:"email,omitempty"`
+}
+
+type PlayerResponse struct {
+	ID                uuid.UUID    `json:"id"`
+	UserID            uuid.UUID    `json:"user_id"`
+	User              UserResponse `json:"user"`
+	TurnOrder         int          `json:"turn_order"`
+	Role              string       `json:"role"`
+	LastReadMessageID *uuid.UUID   `json:"last_read_message_id,omitempty"`
+	AwayUntil         *time.Time   `json:"away_until,omitempty"`
+	AwaySubstituteID  *uuid.UUID   `json:"away_substitute_id,omitempty"`
+	// TimeRemainingSeconds is only set for games with a chess clock.
+	TimeRemainingSeconds *int64     `json:"time_remaining_seconds,omitempty"`
+	OutOfTimeAt          *time.Time `json:"out_of_time_at,omitempty"`
+}
+
+type GameResponse struct {
+	ID             uuid.UUID        `json:"id"`
+	Name           string           `json:"name"`
+	CreatorID      uuid.UUID        `json:"creator_id"`
+	CurrentTurnID  *uuid.UUID       `json:"current_turn_id,omitempty"`
+	TurnStartedAt  *time.Time       `json:"turn_started_at,omitempty"`
+	Round          int              `json:"round,omitempty"` // only for simultaneous games
+	JoinPolicy     string           `json:"join_policy"`
+	MaxPlayers     int              `json:"max_players"`
+	LobbyOpen      bool             `json:"lobby_open"`
+	Status         string           `json:"status"`
+	WinnerID       *uuid.UUID       `json:"winner_id,omitempty"`
+	FinishedAt     *time.Time       `json:"finished_at,omitempty"`
+	Settings       GameSettings     `json:"settings"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Players        []PlayerResponse `json:"players"`
+	UnreadMessages int64            `json:"unread_messages"`
+	// PendingPlayerIDs lists the seats a simultaneous game is waiting for.
+	// It is only shown to members.
+	PendingPlayerIDs []uuid.UUID `json:"pending_player_ids,omitempty"`
+}
+
+// displayName is the user's chosen name, falling back to the local part of
+// their email.
+func displayName(user User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	if i := strings.Index(user.Email, "@"); i > 0 {
+		return user.Email[:i]
+	}
+	return user.Email
+}
+
+// publicName is the user's chosen name, falling back to a name derived from
+// their ID so that responses without emails do not reveal them.
+func publicName(user User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return "Player " + user.ID.String()[:8]
+}
+
+func newUserResponse(user User, withEmail bool) UserResponse {
+	response := UserResponse{
+		ID:          user.ID,
+		DisplayName: publicName(user),
+		AvatarURL:   user.AvatarURL,
+	}
+	if withEmail {
+		response.DisplayName = displayName(user)
+		response.Email = user.Email
+	}
+	return response
+}
+
+// newGameResponse converts a game with its players preloaded. Player emails
+// are included only when withEmails is set.
+func newGameResponse(game Game, withEmails bool) GameResponse {
+	now := time.Now()
+	players := make([]PlayerResponse, 0, len(game.Players))
+	for _, p := range game.Players {
+		player := PlayerResponse{
+			ID:                p.ID,
+			UserID:            p.UserID,
+			User:              newUserResponse(p.User, withEmails),
+			TurnOrder:         p.TurnOrder,
+			Role:              p.Role,
+			LastReadMessageID: p.LastReadMessageID,
+		}
+		// Past away periods are left in the table but not shown.
+		if p.isAway(now) {
+			player.AwayUntil = p.AwayUntil
+			player.AwaySubstituteID = p.AwaySubstituteID
+		}
+		if game.Settings.TimeBankHours > 0 && p.Role != RoleSpectator {
+			remaining := int64(timeRemaining(game, p, now).Seconds())
+			player.TimeRemainingSeconds = &remaining
+			player.OutOfTimeAt = p.OutOfTimeAt
+		}
+		players = append(players, player)
+	}
+	response := GameResponse{
+		ID:            game.ID,
+		Name:          game.Name,
+		CreatorID:     game.CreatorID,
+		CurrentTurnID: game.CurrentTurnID,
+		TurnStartedAt: game.TurnStartedAt,
+		JoinPolicy:    game.JoinPolicy,
+		MaxPlayers:    game.MaxPlayers,
+		LobbyOpen:     game.LobbyOpen,
+		Status:        game.Status,
+		WinnerID:      game.WinnerID,
+		FinishedAt:    game.FinishedAt,
+		Settings:      game.Settings,
+		CreatedAt:     game.CreatedAt,
+		UpdatedAt:     game.UpdatedAt,
+		Players:       players,
+	}
+	if game.Settings.simultaneous() {
+		response.Round = game.Round
+	}
+	return response
+}
+
+type ChatMessageResponse struct {
+	ID        uuid.UUID          `json:"id"`
+	GameID    uuid.UUID          `json:"game_id"`
+	Author    UserResponse       `json:"author"`
+	Body      string             `json:"body"`
+	Mentions  []uuid.UUID        `json:"mentions,omitempty"`
+	Reactions []ReactionResponse `json:"reactions"`
+	CreatedAt time.Time          `json:"created_at"`
+	EditedAt  *time.Time         `json:"edited_at,omitempty"`
+}
+
+// ReactionResponse groups the reactions to a message by emoji.
+type ReactionResponse struct {
+	Emoji   string      `json:"emoji"`
+	Count   int         `json:"count"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// newChatMessageResponse converts a message with its author and reactions
+// preloaded.
+func newChatMessageResponse(message ChatMessage) ChatMessageResponse {
+	reactions := []ReactionResponse{}
+	index := map[string]int{}
+	for _, r := range message.Reactions {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(reactions)
+			index[r.Emoji] = i
+			reactions = append(reactions, ReactionResponse{Emoji: r.Emoji})
+		}
+		reactions[i].Count++
+		reactions[i].UserIDs = append(reactions[i].UserIDs, r.UserID)
+	}
+	return ChatMessageResponse{
+		ID:        message.ID,
+		GameID:    message.GameID,
+		Author:    newUserResponse(message.Author, false),
+		Body:      message.Body,
+		Mentions:  message.Mentions,
+		Reactions: reactions,
+		CreatedAt: message.CreatedAt,
+		EditedAt:  message.EditedAt,
+	}
+}
+
+type SaveResponse struct {
+	ID         uuid.UUID    `json:"id"`
+	GameID     uuid.UUID    `json:"game_id"`
+	PlayerID   *uuid.UUID   `json:"player_id,omitempty"`
+	UploadedBy UserResponse `json:"uploaded_by"`
+	Note       string       `json:"note,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// newSaveResponse converts a save with its uploader preloaded. The file path
+// stays on the server.
+func newSaveResponse(save Save) SaveResponse {
+	return SaveResponse{
+		ID:         save.ID,
+		GameID:     save.GameID,
+		PlayerID:   save.PlayerID,
+		UploadedBy: newUserResponse(save.Uploader, false),
+		Note:       save.Note,
+		CreatedAt:  save.CreatedAt,
+	}
+}
+
+type JoinRequestResponse struct {
+	ID        uuid.UUID    `json:"id"`
+	GameID    uuid.UUID    `json:"game_id"`
+	User      UserResponse `json:"user"`
+	Status    string       `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// newJoinRequestResponse converts a join request with its user preloaded.
+// Applicants are not members yet, so their email stays hidden.
+func newJoinRequestResponse(request JoinRequest) JoinRequestResponse {
+	return JoinRequestResponse{
+		ID:        request.ID,
+		GameID:    request.GameID,
+		User:      newUserResponse(request.User, false),
+		Status:    request.Status,
+		CreatedAt: request.CreatedAt,
+	}
+}
+
+type GameEventResponse struct {
+	ID        uuid.UUID       `json:"id"`
+	GameID    uuid.UUID       `json:"game_id"`
+	Type      string          `json:"type"`
+	Actor     *UserResponse   `json:"actor,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// newGameEventResponse converts an event with its actor preloaded.
+func newGameEventResponse(event GameEvent) GameEventResponse {
+	response := GameEventResponse{
+		ID:        event.ID,
+		GameID:    event.GameID,
+		Type:      event.Type,
+		Data:      event.Data,
+		CreatedAt: event.CreatedAt,
+	}
+	if event.Actor != nil {
+		actor := newUserResponse(*event.Actor, false)
+		response.Actor = &actor
+	}
+	return response
+}
@@ -0,0 +1,236 @@
+package game
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// Permission is something a role may do to a game.
+type Permission string
+
+const (
+	// PermissionManageGame covers settings, lifecycle, lobby, invitations,
+	// join requests and managing players.
+	PermissionManageGame Permission = "manage_game"
+	// PermissionOwnGame covers deleting and restoring the game, assigning
+	// roles and transferring ownership.
+	PermissionOwnGame Permission = "own_game"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {PermissionManageGame, PermissionOwnGame},
+	RoleAdmin: {PermissionManageGame},
+}
+
+// hasPermission reports whether role grants permission.
+func hasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// roleRank orders roles so that users can only manage roles below their own.
+var roleRank = map[string]int{RoleOwner: 3, RoleAdmin: 2, RolePlayer: 1, RoleSpectator: 0}
+
+// roleOf returns userID's role in game, or "" if they are not part of it. The
+// creator is always the owner, which also covers seats created before roles
+// existed.
+func roleOf(db *gorm.DB, game Game, userID uuid.UUID) (string, error) {
+	if game.CreatorID == userID {
+		return RoleOwner, nil
+	}
+	var player Player
+	err := db.Where("game_id = ? AND user_id = ?", game.ID, userID).First(&player).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return player.Role, nil
+}
+
+// authorizeGame loads the game in the :id parameter and checks that the
+// current user's role grants permission. It writes the error response itself
+// and returns ok=false on failure.
+func authorizeGame(c *gin.Context, db *gorm.DB, permission Permission) (game Game, ok bool) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+		return
+	}
+
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		return
+	}
+	if err := db.First(&game, "id = ?", gameID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		return
+	}
+
+	role, err := roleOf(db, game, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !hasPermission(role, permission) {
+		if permission == PermissionOwnGame {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can do this"})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner or an admin can manage this game"})
+		}
+		return
+	}
+	return game, true
+}
+
+// loadMemberGame loads the game in the :id parameter and checks that the
+// current user belongs to it in any role, spectators included. It writes the
+// error response itself and returns ok=false on failure.
+func loadMemberGame(c *gin.Context, db *gorm.DB) (game Game, userID uuid.UUID, ok bool) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+		return
+	}
+
+	gameID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		return
+	}
+	if err := db.First(&game, "id = ?", gameID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		return
+	}
+
+	role, err := roleOf(db, game, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if role == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this game"})
+		return
+	}
+	return game, userID, true
+}
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type TransferOwnershipRequest struct {
+	PlayerID uuid.UUID `json:"player_id" binding:"required"`
+}
+
+// SetPlayerRoleHandler lets the owner promote players to admin or demote
+// them. Ownership changes go through TransferOwnershipHandler.
+func SetPlayerRoleHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionOwnGame)
+		if !ok {
+			return
+		}
+
+		var req RoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Role != RoleAdmin && req.Role != RolePlayer) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be \"admin\" or \"player\""})
+			return
+		}
+
+		playerID, err := uuid.Parse(c.Param("playerId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+		var player Player
+		if err := db.Where("id = ? AND game_id = ?", playerID, game.ID).First(&player).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+		if player.UserID == game.CreatorID {
+			c.JSON(http.StatusConflict, gin.H{"error": "the owner's role cannot be changed; transfer ownership instead"})
+			return
+		}
+		if player.Role == RoleSpectator {
+			c.JSON(http.StatusConflict, gin.H{"error": "spectators cannot be given a role"})
+			return
+		}
+
+		if err := db.Model(&player).Update("role", req.Role).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "player_role_changed", map[string]interface{}{
+			"game_id":   game.ID.String(),
+			"player_id": player.ID.String(),
+			"role":      req.Role,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Role updated", "player_id": player.ID, "role": req.Role})
+	}
+}
+
+// TransferOwnershipHandler hands the game to another player. The previous
+// owner stays in the game as an admin.
+func TransferOwnershipHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionOwnGame)
+		if !ok {
+			return
+		}
+
+		var req TransferOwnershipRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "player_id is required"})
+			return
+		}
+
+		var newOwner Player
+		if err := db.Where("id = ? AND game_id = ?", req.PlayerID, game.ID).First(&newOwner).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "player not found"})
+			return
+		}
+		if newOwner.UserID == game.CreatorID {
+			c.JSON(http.StatusConflict, gin.H{"error": "player already owns this game"})
+			return
+		}
+		if newOwner.Role == RoleSpectator {
+			c.JSON(http.StatusConflict, gin.H{"error": "ownership cannot be given to a spectator"})
+			return
+		}
+
+		previousOwnerID := game.CreatorID
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&Player{}).Where("game_id = ? AND user_id = ?", game.ID, previousOwnerID).
+				Update("role", RoleAdmin).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&newOwner).Update("role", RoleOwner).Error; err != nil {
+				return err
+			}
+			return tx.Model(&game).Update("creator_id", newOwner.UserID).Error
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "ownership_transferred", map[string]interface{}{
+			"game_id":           game.ID.String(),
+			"owner_id":          newOwner.UserID.String(),
+			"previous_owner_id": previousOwnerID.String(),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred", "owner_id": newOwner.UserID})
+	}
+}
@@ -0,0 +1,123 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// Turn modes. In rotation games players take turns in TurnOrder; in
+// simultaneous games everybody submits one save per round.
+const (
+	TurnModeRotation     = "rotation"
+	TurnModeSimultaneous = "simultaneous"
+)
+
+func (s GameSettings) simultaneous() bool {
+	return s.TurnMode == TurnModeSimultaneous
+}
+
+// submittedThisRound reports whether a seat already has a save for the
+// game's current round.
+func submittedThisRound(db *gorm.DB, game Game, seat Player) (bool, error) {
+	var count int64
+	err := db.Model(&Save{}).Where("game_id = ? AND player_id = ? AND round = ?", game.ID, seat.ID, game.Round).Count(&count).Error
+	return count > 0, err
+}
+
+// roundSeats splits the seats of a simultaneous game into those who have
+// submitted a save for the current round and those still pending. Players who
+// are away without a substitute are not waited for.
+func roundSeats(db *gorm.DB, game Game) (submitted, pending []Player, err error) {
+	var players []Player
+	if err := db.Scopes(seated).Where("game_id = ?", game.ID).Order("turn_order ASC").Find(&players).Error; err != nil {
+		return nil, nil, err
+	}
+	var done []uuid.UUID
+	if err := db.Model(&Save{}).Where("game_id = ? AND round = ? AND player_id IS NOT NULL", game.ID, game.Round).
+		Distinct().Pluck("player_id", &done).Error; err != nil {
+		return nil, nil, err
+	}
+	doneSet := make(map[uuid.UUID]bool, len(done))
+	for _, id := range done {
+		doneSet[id] = true
+	}
+
+	now := time.Now()
+	for _, p := range players {
+		switch {
+		case doneSet[p.ID]:
+			submitted = append(submitted, p)
+		case p.isAway(now) && p.AwaySubstituteID == nil:
+		default:
+			pending = append(pending, p)
+		}
+	}
+	return submitted, pending, nil
+}
+
+// pendingPlayerIDs lists the seats a simultaneous game is still waiting for.
+func pendingPlayerIDs(db *gorm.DB, game Game) ([]uuid.UUID, error) {
+	_, pending, err := roundSeats(db, game)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+	}
+	return ids, nil
+}
+
+// completeRound starts the next round of a simultaneous game once nobody is
+// pending, and tells everyone it has begun. It is safe to call after any
+// change that could leave nobody pending.
+func completeRound(db *gorm.DB, sseManager sse.Broadcaster, notifier Notifier, game Game, actorID uuid.UUID) {
+	if !game.Settings.simultaneous() || game.Status != GameStatusActive {
+		return
+	}
+	submitted, pending, err := roundSeats(db, game)
+	if err != nil {
+		fmt.Printf("Warning: failed to check round %d of game %s: %v\n", game.Round, game.ID, err)
+		return
+	}
+	if len(pending) > 0 || len(submitted) == 0 {
+		return
+	}
+
+	// Only one of several concurrent uploads gets to close the round.
+	result := db.Model(&Game{}).Where("id = ? AND round = ?", game.ID, game.Round).Update("round", game.Round+1)
+	if result.Error != nil {
+		fmt.Printf("Warning: failed to advance round of game %s: %v\n", game.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	publishEvent(db, sseManager, game.ID, actorID, "round_advanced", map[string]interface{}{
+		"game_id": game.ID.String(),
+		"round":   game.Round + 1,
+	})
+
+	var players []Player
+	if err := db.Preload("User").Scopes(seated).Where("game_id = ?", game.ID).Find(&players).Error; err != nil {
+		fmt.Printf("Warning: failed to get players for notification: %v\n", err)
+		return
+	}
+	subject := fmt.Sprintf("Round %d of %s has started", game.Round+1, game.Name)
+	body := fmt.Sprintf("Everyone has submitted their turn for round %d of %s. It's time to play round %d!", game.Round, game.Name, game.Round+1)
+	for _, p := range players {
+		recipient, ok := turnRecipient(db, p)
+		if !ok || recipient.Email == "" {
+			continue
+		}
+		if err := notifier.Notify(recipient.Email, subject, body); err != nil {
+			fmt.Printf("Warning: failed to send email to %s: %v\n", recipient.Email, err)
+		}
+	}
+}
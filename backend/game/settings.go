@@ -0,0 +1,350 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/sse"
+)
+
+const (
+	maxDescriptionLength = 2000
+	maxHouseRulesLength  = 5000
+	maxMods              = 100
+	maxModNameLength     = 200
+	maxTurnDeadlineHours = 30 * 24
+	maxTimeBankHours     = 365 * 24
+)
+
+// optionCheck validates a single game-specific option value.
+type optionCheck func(value interface{}) error
+
+func oneOf(allowed ...string) optionCheck {
+	return func(value interface{}) error {
+		s, ok := value.(string)
+		if ok {
+			for _, a := range allowed {
+				if s == a {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func isBool(value interface{}) error {
+	if _, ok := value.(bool); !ok {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+func numberBetween(min, max float64) optionCheck {
+	return func(value interface{}) error {
+		n, ok := value.(float64)
+		if !ok || n < min || n > max {
+			return fmt.Errorf("must be a number between %g and %g", min, max)
+		}
+		return nil
+	}
+}
+
+// gameTypeSpec lists what a game type accepts. A nil MapTypes or Options
+// accepts anything.
+type gameTypeSpec struct {
+	MapTypes []string
+	Options  map[string]optionCheck
+}
+
+var civDifficulties = []string{"settler", "chieftain", "warlord", "prince", "king", "emperor", "immortal", "deity"}
+
+// gameTypes holds the supported values for GameSettings.GameType.
+var gameTypes = map[string]gameTypeSpec{
+	"civ5": {
+		MapTypes: []string{"continents", "pangaea", "fractal", "archipelago", "small_continents", "inland_sea", "terra", "earth"},
+		Options: map[string]optionCheck{
+			"game_speed":  oneOf("quick", "standard", "epic", "marathon"),
+			"difficulty":  oneOf(civDifficulties...),
+			"barbarians":  isBool,
+			"city_states": numberBetween(0, 41),
+		},
+	},
+	"civ6": {
+		MapTypes: []string{"continents", "pangaea", "fractal", "archipelago", "inland_sea", "small_continents", "island_plates", "seven_seas", "shuffle", "terra", "earth"},
+		Options: map[string]optionCheck{
+			"game_speed":       oneOf("online", "quick", "standard", "epic", "marathon"),
+			"difficulty":       oneOf(civDifficulties...),
+			"barbarians":       isBool,
+			"city_states":      numberBetween(0, 24),
+			"secret_societies": isBool,
+		},
+	},
+	"oldworld": {
+		MapTypes: []string{"continent", "inland_sea", "lakes_and_gulfs", "coastal_rain_basin", "archipelago", "donut", "mediterranean", "highlands", "northern_oceans"},
+		Options: map[string]optionCheck{
+			"turn_scale":     oneOf("year", "semester", "season", "month"),
+			"victory_points": numberBetween(1, 1000),
+			"difficulty":     oneOf("peaceful", "pleasant", "strong", "capable", "noble", "glorious", "magnificent", "great"),
+		},
+	},
+	"other": {},
+}
+
+func supportedGameTypes() []string {
+	names := make([]string, 0, len(gameTypes))
+	for name := range gameTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateSettings checks settings against the limits shared by all games and
+// the rules of its game type. An empty game type accepts no options.
+func validateSettings(settings GameSettings) error {
+	if len(settings.Description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	if len(settings.HouseRules) > maxHouseRulesLength {
+		return fmt.Errorf("house_rules must be at most %d characters", maxHouseRulesLength)
+	}
+	if len(settings.Mods) > maxMods {
+		return fmt.Errorf("at most %d mods are allowed", maxMods)
+	}
+	for _, mod := range settings.Mods {
+		if strings.TrimSpace(mod) == "" || len(mod) > maxModNameLength {
+			return fmt.Errorf("mod names must be between 1 and %d characters", maxModNameLength)
+		}
+	}
+	if settings.TurnDeadlineHours < 0 || settings.TurnDeadlineHours > maxTurnDeadlineHours {
+		return fmt.Errorf("turn_deadline_hours must be between 0 and %d", maxTurnDeadlineHours)
+	}
+	if settings.TimeBankHours < 0 || settings.TimeBankHours > maxTimeBankHours {
+		return fmt.Errorf("time_bank_hours must be between 0 and %d", maxTimeBankHours)
+	}
+	switch settings.TurnMode {
+	case "", TurnModeRotation, TurnModeSimultaneous:
+	default:
+		return fmt.Errorf("turn_mode must be %s or %s", TurnModeRotation, TurnModeSimultaneous)
+	}
+	if settings.simultaneous() && settings.TimeBankHours > 0 {
+		return fmt.Errorf("time_bank_hours is not available in %s games", TurnModeSimultaneous)
+	}
+	switch settings.TimeBankPenalty {
+	case "", ClockPenaltyNotify, ClockPenaltySkip, ClockPenaltyPause:
+	default:
+		return fmt.Errorf("time_bank_penalty must be one of %s, %s, %s", ClockPenaltyNotify, ClockPenaltySkip, ClockPenaltyPause)
+	}
+
+	if settings.GameType == "" {
+		if len(settings.Options) > 0 {
+			return fmt.Errorf("options require a game_type")
+		}
+		return nil
+	}
+	spec, ok := gameTypes[settings.GameType]
+	if !ok {
+		return fmt.Errorf("game_type must be one of %s", strings.Join(supportedGameTypes(), ", "))
+	}
+
+	if spec.MapTypes != nil && settings.MapType != "" {
+		found := false
+		for _, m := range spec.MapTypes {
+			found = found || m == settings.MapType
+		}
+		if !found {
+			return fmt.Errorf("map_type for %s must be one of %s", settings.GameType, strings.Join(spec.MapTypes, ", "))
+		}
+	}
+	if spec.Options != nil {
+		for key, value := range settings.Options {
+			check, ok := spec.Options[key]
+			if !ok {
+				return fmt.Errorf("unknown option %q for %s", key, settings.GameType)
+			}
+			if err := check(value); err != nil {
+				return fmt.Errorf("option %q %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GameSettingsPatch holds the settings fields to change; nil fields are kept.
+// Options, when present, replaces the whole option set.
+type GameSettingsPatch struct {
+	GameType          *string                `json:"game_type"`
+	Description       *string                `json:"description"`
+	MapType           *string                `json:"map_type"`
+	Mods              *[]string              `json:"mods"`
+	HouseRules        *string                `json:"house_rules"`
+	TurnMode          *string                `json:"turn_mode"`
+	TurnDeadlineHours *int                   `json:"turn_deadline_hours"`
+	TimeBankHours     *int                   `json:"time_bank_hours"`
+	TimeBankPenalty   *string                `json:"time_bank_penalty"`
+	Options           map[string]interface{} `json:"options"`
+}
+
+func (p GameSettingsPatch) apply(settings GameSettings) GameSettings {
+	if p.GameType != nil {
+		// Options and map types don't carry over between game types.
+		if *p.GameType != settings.GameType {
+			settings.Options = nil
+			settings.MapType = ""
+		}
+		settings.GameType = *p.GameType
+	}
+	if p.Description != nil {
+		settings.Description = *p.Description
+	}
+	if p.MapType != nil {
+		settings.MapType = *p.MapType
+	}
+	if p.Mods != nil {
+		settings.Mods = *p.Mods
+	}
+	if p.HouseRules != nil {
+		settings.HouseRules = *p.HouseRules
+	}
+	if p.TurnMode != nil {
+		settings.TurnMode = *p.TurnMode
+	}
+	if p.TurnDeadlineHours != nil {
+		settings.TurnDeadlineHours = *p.TurnDeadlineHours
+	}
+	if p.TimeBankHours != nil {
+		settings.TimeBankHours = *p.TimeBankHours
+	}
+	if p.TimeBankPenalty != nil {
+		settings.TimeBankPenalty = *p.TimeBankPenalty
+	}
+	if p.Options != nil {
+		settings.Options = p.Options
+	}
+	return settings
+}
+
+// changedColumns lists the settings columns whose values differ from old.
+func (s GameSettings) changedColumns(old GameSettings) []string {
+	var columns []string
+	add := func(changed bool, column string) {
+		if changed {
+			columns = append(columns, "settings_"+column)
+		}
+	}
+	add(s.GameType != old.GameType, "game_type")
+	add(s.Description != old.Description, "description")
+	add(s.MapType != old.MapType, "map_type")
+	add(!reflect.DeepEqual(s.Mods, old.Mods), "mods")
+	add(s.HouseRules != old.HouseRules, "house_rules")
+	add(s.TurnMode != old.TurnMode, "turn_mode")
+	add(s.TurnDeadlineHours != old.TurnDeadlineHours, "turn_deadline_hours")
+	add(s.TimeBankHours != old.TimeBankHours, "time_bank_hours")
+	add(s.TimeBankPenalty != old.TimeBankPenalty, "time_bank_penalty")
+	add(!reflect.DeepEqual(s.Options, old.Options), "options")
+	return columns
+}
+
+type UpdateGameRequest struct {
+	Name       *string            `json:"name"`
+	JoinPolicy *string            `json:"join_policy"`
+	MaxPlayers *int               `json:"max_players"`
+	Settings   *GameSettingsPatch `json:"settings"`
+}
+
+// UpdateGameHandler lets the owner or an admin edit a game after creation.
+// Only the columns the request changes are written, so concurrent turn and
+// status changes are not overwritten.
+func UpdateGameHandler(db *gorm.DB, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := authorizeGame(c, db, PermissionManageGame)
+		if !ok {
+			return
+		}
+
+		var req UpdateGameRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		var columns []string
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+				return
+			}
+			var existing Game
+			if err := db.Unscoped().Where("name = ? AND id <> ?", name, game.ID).First(&existing).Error; err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A game with this name already exists."})
+				return
+			}
+			game.Name = name
+			columns = append(columns, "name")
+		}
+		if req.JoinPolicy != nil {
+			if !validJoinPolicy(*req.JoinPolicy) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "join_policy must be \"open\", \"invite_only\" or \"approval\""})
+				return
+			}
+			game.JoinPolicy = *req.JoinPolicy
+			columns = append(columns, "join_policy")
+		}
+		if req.MaxPlayers != nil {
+			if *req.MaxPlayers < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "max_players cannot be negative"})
+				return
+			}
+			var count int64
+			if err := db.Model(&Player{}).Scopes(seated).Where("game_id = ?", game.ID).Count(&count).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if *req.MaxPlayers > 0 && int64(*req.MaxPlayers) < count {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "max_players is below the current number of players"})
+				return
+			}
+			game.MaxPlayers = *req.MaxPlayers
+			columns = append(columns, "max_players")
+		}
+		if req.Settings != nil {
+			settings := req.Settings.apply(game.Settings)
+			if err := validateSettings(settings); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if settings.simultaneous() != game.Settings.simultaneous() && game.Status != GameStatusLobby {
+				c.JSON(http.StatusConflict, gin.H{"error": "turn_mode can only be changed before the game starts"})
+				return
+			}
+			columns = append(columns, settings.changedColumns(game.Settings)...)
+			game.Settings = settings
+		}
+
+		if len(columns) > 0 {
+			if err := db.Model(&game).Select(columns).Updates(&game).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update game"})
+				return
+			}
+		}
+		if err := db.Preload("Players.User").First(&game, "id = ?", game.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload game"})
+			return
+		}
+
+		publishEvent(db, sseManager, game.ID, actorOf(c), "game_updated", map[string]interface{}{
+			"game_id": game.ID.String(),
+		})
+
+		c.JSON(http.StatusOK, newGameResponse(game, true))
+	}
+}
@@ -0,0 +1,83 @@
+package game
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+	"panzerstadt/async-multiplayer/sse"
+)
+
+// SpectateGameHandler adds the current user to a game as a spectator. Open
+// games can be watched by anyone, even once the lobby has closed; other games
+// need a spectator invitation, matched by email or the ?invite= token.
+func SpectateGameHandler(db *gorm.DB, cfg config.Config, sseManager sse.Broadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		gameID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+
+		var game Game
+		if err := db.First(&game, "id = ?", gameID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		var existing int64
+		if err := db.Model(&Player{}).Where("game_id = ? AND user_id = ?", gameID, userID).Count(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "already a participant"})
+			return
+		}
+
+		invitation, err := findInvitationForJoin(db, cfg, gameID, user, c.Query("invite"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invitations"})
+			return
+		}
+		if invitation != nil && invitation.Role != RoleSpectator {
+			invitation = nil
+		}
+		if invitation == nil && game.JoinPolicy != JoinPolicyOpen {
+			c.JSON(http.StatusForbidden, gin.H{"error": "an invitation is required to spectate this game"})
+			return
+		}
+
+		var player Player
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if invitation != nil {
+				player, err = acceptInvitation(tx, invitation, userID)
+				return err
+			}
+			player, err = addPlayerToGame(tx, gameID, userID, RoleSpectator)
+			return err
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to spectate game"})
+			return
+		}
+		broadcastPlayerJoined(db, sseManager, player, userID)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Spectating game", "player_id": player.ID})
+	}
+}
@@ -0,0 +1,252 @@
+package game
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TurnStats summarises someone's turns. A turn lasts from the save before it
+// to the save that ends it, so the first save of a game counts as a turn
+// without a duration. In simultaneous games a turn lasts from the start of its
+// round instead, and turns in the first round have no duration. Durations are
+// in seconds.
+type TurnStats struct {
+	TurnsTaken         int   `json:"turns_taken"`
+	AverageTurnSeconds int64 `json:"average_turn_seconds"`
+	MedianTurnSeconds  int64 `json:"median_turn_seconds"`
+	LongestTurnSeconds int64 `json:"longest_turn_seconds"`
+	// LateTurns counts turns that took longer than the game's turn deadline.
+	LateTurns int `json:"late_turns"`
+}
+
+type PlayerStats struct {
+	User UserResponse `json:"user"`
+	TurnStats
+}
+
+type GameStats struct {
+	GameID              uuid.UUID     `json:"game_id"`
+	TurnsTaken          int           `json:"turns_taken"`
+	TurnsPerWeek        float64       `json:"turns_per_week"`
+	TotalElapsedSeconds int64         `json:"total_elapsed_seconds"`
+	Players             []PlayerStats `json:"players"`
+}
+
+type UserStats struct {
+	GamesPlayed int `json:"games_played"`
+	TurnStats
+}
+
+// turnLog collects one user's turns across one or more games.
+type turnLog struct {
+	turns     int
+	late      int
+	durations []time.Duration
+}
+
+func (l *turnLog) stats() TurnStats {
+	stats := TurnStats{TurnsTaken: l.turns, LateTurns: l.late}
+	if len(l.durations) == 0 {
+		return stats
+	}
+
+	sorted := append([]time.Duration(nil), l.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+
+	stats.AverageTurnSeconds = int64((total / time.Duration(len(sorted))).Seconds())
+	stats.MedianTurnSeconds = int64(median.Seconds())
+	stats.LongestTurnSeconds = int64(sorted[len(sorted)-1].Seconds())
+	return stats
+}
+
+// collectTurns adds the turns in a game's saves, oldest first, to the logs of
+// the users who uploaded them. Turns of simultaneous games are timed from
+// roundStarts.
+func collectTurns(game Game, saves []Save, roundStarts map[int]time.Time, logs map[uuid.UUID]*turnLog) {
+	deadline := time.Duration(game.Settings.TurnDeadlineHours) * time.Hour
+	for i, save := range saves {
+		log, ok := logs[save.UploadedBy]
+		if !ok {
+			log = &turnLog{}
+			logs[save.UploadedBy] = log
+		}
+		log.turns++
+
+		var start time.Time
+		if game.Settings.simultaneous() {
+			start = roundStarts[save.Round]
+		} else if i > 0 {
+			start = saves[i-1].CreatedAt
+		}
+		if start.IsZero() {
+			continue
+		}
+		duration := save.CreatedAt.Sub(start)
+		log.durations = append(log.durations, duration)
+		if deadline > 0 && duration > deadline {
+			log.late++
+		}
+	}
+}
+
+// roundStarts returns when each round of a simultaneous game after the first
+// began: at its round_advanced event, or failing that at the last save of the
+// round before. It returns nil for rotation games.
+func roundStarts(db *gorm.DB, game Game, saves []Save) (map[int]time.Time, error) {
+	if !game.Settings.simultaneous() {
+		return nil, nil
+	}
+	starts := map[int]time.Time{}
+	for _, save := range saves {
+		if save.Round > 0 && save.CreatedAt.After(starts[save.Round+1]) {
+			starts[save.Round+1] = save.CreatedAt
+		}
+	}
+
+	var events []GameEvent
+	if err := db.Where("game_id = ? AND type = ?", game.ID, "round_advanced").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		var data struct {
+			Round int `json:"round"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err == nil && data.Round > 0 {
+			starts[data.Round] = event.CreatedAt
+		}
+	}
+	return starts, nil
+}
+
+// gameElapsed is how long a game has been running: until it finished, or
+// until now.
+func gameElapsed(game Game) time.Duration {
+	end := time.Now()
+	if game.FinishedAt != nil {
+		end = *game.FinishedAt
+	}
+	return end.Sub(game.CreatedAt)
+}
+
+// GetGameStatsHandler reports turn statistics for a game and each of its
+// players, including players who have since left.
+func GetGameStatsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, _, ok := loadMemberGame(c, db)
+		if !ok {
+			return
+		}
+
+		var saves []Save
+		if err := db.Preload("Uploader").Where("game_id = ?", game.ID).Order("created_at ASC").Find(&saves).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve saves"})
+			return
+		}
+		var players []Player
+		if err := db.Scopes(seated).Preload("User").Where("game_id = ?", game.ID).Order("turn_order ASC").Find(&players).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve players"})
+			return
+		}
+
+		starts, err := roundStarts(db, game, saves)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rounds"})
+			return
+		}
+		logs := map[uuid.UUID]*turnLog{}
+		collectTurns(game, saves, starts, logs)
+
+		elapsed := gameElapsed(game)
+		stats := GameStats{
+			GameID:              game.ID,
+			TurnsTaken:          len(saves),
+			TotalElapsedSeconds: int64(elapsed.Seconds()),
+			Players:             make([]PlayerStats, 0, len(players)),
+		}
+		if weeks := elapsed.Hours() / (24 * 7); weeks > 0 {
+			stats.TurnsPerWeek = float64(len(saves)) / weeks
+		}
+
+		listed := map[uuid.UUID]bool{}
+		addPlayer := func(user User) {
+			listed[user.ID] = true
+			player := PlayerStats{User: newUserResponse(user, true)}
+			if log, ok := logs[user.ID]; ok {
+				player.TurnStats = log.stats()
+			}
+			stats.Players = append(stats.Players, player)
+		}
+		for _, p := range players {
+			addPlayer(p.User)
+		}
+		for _, save := range saves {
+			if !listed[save.UploadedBy] {
+				addPlayer(save.Uploader)
+			}
+		}
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// GetUserStatsHandler reports the current user's turn statistics across all
+// of their games.
+func GetUserStatsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var gamesPlayed int64
+		if err := db.Model(&Player{}).Scopes(seated).
+			Joins("JOIN games ON games.id = players.game_id AND games.deleted_at IS NULL").
+			Where("players.user_id = ?", userID).Count(&gamesPlayed).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count games"})
+			return
+		}
+
+		var games []Game
+		if err := db.Where("id IN (?)", db.Model(&Save{}).Select("game_id").Where("uploaded_by = ?", userID)).
+			Find(&games).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve games"})
+			return
+		}
+
+		logs := map[uuid.UUID]*turnLog{}
+		for _, game := range games {
+			var saves []Save
+			if err := db.Where("game_id = ?", game.ID).Order("created_at ASC").Find(&saves).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve saves"})
+				return
+			}
+			starts, err := roundStarts(db, game, saves)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rounds"})
+				return
+			}
+			collectTurns(game, saves, starts, logs)
+		}
+
+		stats := UserStats{GamesPlayed: int(gamesPlayed)}
+		if log, ok := logs[userID]; ok {
+			stats.TurnStats = log.stats()
+		}
+		c.JSON(http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,345 @@
+package game
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"panzerstadt/async-multiplayer/config"
+)
+
+const (
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+	// loginCodeTTL only needs to cover the frontend loading after the
+	// login redirect.
+	loginCodeTTL = 2 * time.Minute
+)
+
+var errTokenAlreadyUsed = fmt.Errorf("refresh token already used")
+
+// TokenPair is returned to clients after login or refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LoginCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func issueAccessToken(cfg config.Config, user User) (string, error) {
+	now := time.Now()
+	return SignToken(cfg, Claims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   user.ID.String(),
+			Issuer:    cfg.JwtIssuer,
+			Audience:  jwt.ClaimStrings{cfg.JwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
+		},
+	})
+}
+
+func issueRefreshToken(db *gorm.DB, cfg config.Config, userID, familyID uuid.UUID) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	record := RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(cfg.RefreshTokenTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// IssueTokens creates an access token and starts a new refresh token family.
+func IssueTokens(db *gorm.DB, cfg config.Config, user User) (TokenPair, error) {
+	return issueTokenPair(db, cfg, user, uuid.New())
+}
+
+func issueTokenPair(db *gorm.DB, cfg config.Config, user User, familyID uuid.UUID) (TokenPair, error) {
+	accessToken, err := issueAccessToken(cfg, user)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+	refreshToken, err := issueRefreshToken(db, cfg, user.ID, familyID)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Auth cookies are SameSite=Lax so that other sites cannot make
+// authenticated state-changing requests with them.
+func setAuthCookies(c *gin.Context, cfg config.Config, tokens TokenPair) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(accessTokenCookie, tokens.AccessToken, int(cfg.AccessTokenTTL.Seconds()), "/", "", true, true)
+	c.SetCookie(refreshTokenCookie, tokens.RefreshToken, int(cfg.RefreshTokenTTL.Seconds()), "/auth", "", true, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(accessTokenCookie, "", -1, "/", "", true, true)
+	c.SetCookie(refreshTokenCookie, "", -1, "/auth", "", true, true)
+}
+
+// completeLogin signs in a user who just authenticated and sends them back
+// to the frontend. In cookie mode the tokens are set as cookies; otherwise the
+// redirect carries a single-use code for ExchangeLoginCodeHandler.
+func completeLogin(c *gin.Context, db *gorm.DB, cfg config.Config, user User) {
+	// After a POST, such as a confirmed login link, the browser must follow
+	// the redirect with a GET.
+	status := http.StatusTemporaryRedirect
+	if c.Request.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+
+	if cfg.AuthCookieMode {
+		tokens, err := IssueTokens(db, cfg, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		setAuthCookies(c, cfg, tokens)
+		c.Redirect(status, cfg.FrontendUrl)
+		return
+	}
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate login code"})
+		return
+	}
+	record := LoginCode{UserID: user.ID, CodeHash: hashToken(code), ExpiresAt: time.Now().Add(loginCodeTTL)}
+	if err := db.Create(&record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate login code"})
+		return
+	}
+
+	query := url.Values{}
+	query.Set("code", code)
+	c.Redirect(status, fmt.Sprintf("%s?%s", cfg.FrontendUrl, query.Encode()))
+}
+
+// ExchangeLoginCodeHandler trades the code from a login redirect for a token
+// pair. Each code works once.
+func ExchangeLoginCodeHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LoginCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+			return
+		}
+
+		var record LoginCode
+		if err := db.Where("code_hash = ?", hashToken(req.Code)).First(&record).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid login code"})
+			return
+		}
+		result := db.Model(&LoginCode{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", record.ID, time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login code has already been used or has expired"})
+			return
+		}
+		// Used and expired codes are of no further use.
+		db.Where("expires_at < ?", time.Now()).Delete(&LoginCode{})
+
+		var user User
+		if err := db.First(&user, "id = ?", record.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		tokens, err := IssueTokens(db, cfg, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func refreshTokenFromRequest(c *gin.Context) string {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		return req.RefreshToken
+	}
+	if cookie, err := c.Cookie(refreshTokenCookie); err == nil {
+		return cookie
+	}
+	return ""
+}
+
+// revokeTokenFamily revokes every still active refresh token of a login.
+func revokeTokenFamily(db *gorm.DB, familyID uuid.UUID) error {
+	return db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RefreshHandler exchanges a refresh token for a new token pair. The presented
+// token is revoked; presenting an already revoked token is treated as theft
+// and revokes the whole family.
+func RefreshHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := refreshTokenFromRequest(c)
+		if presented == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token is required"})
+			return
+		}
+
+		var record RefreshToken
+		if err := db.Where("token_hash = ?", hashToken(presented)).First(&record).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+
+		if record.RevokedAt != nil {
+			if err := revokeTokenFamily(db, record.FamilyID); err != nil {
+				fmt.Printf("Warning: failed to revoke token family %s: %v\n", record.FamilyID, err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has been revoked"})
+			return
+		}
+
+		if time.Now().After(record.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has expired"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", record.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		var tokens TokenPair
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Guard against two concurrent refreshes of the same token.
+			result := tx.Model(&RefreshToken{}).
+				Where("id = ? AND revoked_at IS NULL", record.ID).
+				Update("revoked_at", time.Now())
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errTokenAlreadyUsed
+			}
+
+			var err error
+			tokens, err = issueTokenPair(tx, cfg, user, record.FamilyID)
+			return err
+		})
+		if err == errTokenAlreadyUsed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has been revoked"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+			return
+		}
+
+		// Expired tokens of this user are no longer useful for reuse detection.
+		db.Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&RefreshToken{})
+
+		if cfg.AuthCookieMode {
+			setAuthCookies(c, cfg, tokens)
+			c.JSON(http.StatusOK, gin.H{"token_type": tokens.TokenType, "expires_in": tokens.ExpiresIn})
+			return
+		}
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// LogoutHandler revokes the access token used for the request and the refresh
+// token family it belongs to. With ?all=true every session of the user is
+// ended.
+func LogoutHandler(db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		jti := c.GetString("tokenID")
+		expiresAt, _ := c.Get("tokenExpiresAt")
+		if exp, ok := expiresAt.(time.Time); ok && jti != "" {
+			if err := db.Create(&RevokedToken{JTI: jti, ExpiresAt: exp}).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+				return
+			}
+		}
+		// Deny-list entries are only needed until the token would expire.
+		db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+
+		if c.Query("all") == "true" {
+			if err := db.Model(&RefreshToken{}).
+				Where("user_id = ? AND revoked_at IS NULL", userID).
+				Update("revoked_at", time.Now()).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+				return
+			}
+		} else if presented := refreshTokenFromRequest(c); presented != "" {
+			var record RefreshToken
+			if err := db.Where("token_hash = ? AND user_id = ?", hashToken(presented), userID).First(&record).Error; err == nil {
+				if err := revokeTokenFamily(db, record.FamilyID); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh token"})
+					return
+				}
+			}
+		}
+
+		if cfg.AuthCookieMode {
+			clearAuthCookies(c)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+	}
+}
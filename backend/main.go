@@ -31,13 +31,17 @@ func main() {
 
 	// Initialize Gin router with custom error middleware
 	r := gin.New()
+	// Only proxies we run may tell us the client IP that rate limits key on
+	if err := r.SetTrustedProxies(cfg.TrustedProxyList()); err != nil {
+		log.Fatal("invalid TRUSTED_PROXIES:", err)
+	}
 	r.Use(gin.Recovery())
 	r.Use(game.ErrorHandlingMiddleware())
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{cfg.FrontendUrl},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -53,7 +57,10 @@ func main() {
 	}
 
 	// Perform initial database migration
-	db.AutoMigrate(&game.User{}, &game.Game{}, &game.Player{}, &game.Save{})
+	db.AutoMigrate(&game.User{}, &game.UserIdentity{}, &game.Game{}, &game.Player{}, &game.Save{}, &game.RefreshToken{}, &game.RevokedToken{}, &game.MagicLink{}, &game.LoginCode{}, &game.Invitation{}, &game.JoinRequest{}, &game.ChatMessage{}, &game.ChatReaction{}, &game.GameEvent{})
+
+	// Permanently remove deleted games once their grace period is over
+	game.StartPurgeJob(db, cfg.PurgeInterval, cfg.DeletedGameGracePeriod)
 
 	// Initialize OAuth
 	game.InitOAuth(cfg)
@@ -61,39 +68,90 @@ func main() {
 	// Initialize Mailgun Notifier
 	mailgunNotifier := game.NewMailgunNotifier(cfg)
 
+	// Apply chess clock penalties to players who run out of time
+	game.StartClockJob(db, sseManager, mailgunNotifier, cfg.ClockCheckInterval)
+
 	// Define API routes
-	r.POST("/create-game", game.AuthMiddleware(cfg), game.CreateGameHandler(db))
-	r.POST("/join-game/:id", game.JoinGameHandler(db))
-	r.GET("/auth/google/login", game.GoogleLoginHandler(cfg))
-	r.GET("/auth/google/callback", game.GoogleCallbackHandler(db, cfg))
-
-	// SSE endpoint
-	r.GET("/sse/notifications", func(c *gin.Context) {
-		// TODO: serve SSE per game room
-		// 1. get user, get gameID (from params)
-		// 2. serve only to the same game
-		sse.ServeSSE(sseManager, c)
-	})
+	r.POST("/create-game", game.AuthMiddleware(db, cfg), game.CreateGameHandler(db, cfg, mailgunNotifier))
+	r.POST("/join-game/:id", game.AuthMiddleware(db, cfg), game.JoinGameHandler(db, cfg, sseManager))
+	r.GET("/auth/:provider/login", game.ProviderLoginHandler(cfg))
+	r.GET("/auth/:provider/callback", game.ProviderCallbackHandler(db, cfg))
+	r.POST("/auth/email/login", game.RateLimitMiddleware(cfg.LoginRateLimit, cfg.LoginRateWindow, cfg.RateLimitIdleExpiry), game.RequestMagicLinkHandler(db, cfg, mailgunNotifier))
+	r.GET("/auth/email/callback", game.MagicLinkCallbackHandler(db, cfg))
+	r.POST("/auth/email/callback", game.ConfirmMagicLinkHandler(db, cfg))
+	r.POST("/auth/token", game.ExchangeLoginCodeHandler(db, cfg))
+	r.POST("/auth/refresh", game.RefreshHandler(db, cfg))
+	r.POST("/auth/logout", game.AuthMiddleware(db, cfg), game.LogoutHandler(db, cfg))
+
+	// SSE endpoint; each user only receives the events of their own games
+	r.GET("/sse/notifications", game.TokenQueryMiddleware(), game.AuthMiddleware(db, cfg), game.NotificationsHandler(db, sseManager))
+
+	// Chat messages share one rate limit, whichever route they come through.
+	messageRateLimit := game.RateLimitMiddleware(cfg.MessageRateLimit, cfg.MessageRateWindow, cfg.RateLimitIdleExpiry)
 
 	// Authenticated routes
 	authed := r.Group("/api")
-	authed.Use(game.AuthMiddleware(cfg))
+	authed.Use(game.AuthMiddleware(db, cfg))
 	authed.GET("/user/games", game.GetUserGamesHandler(db))
+	authed.GET("/user/stats", game.GetUserStatsHandler(db))
+	authed.GET("/user/me", game.GetProfileHandler(db))
+	authed.PATCH("/user/me", game.UpdateProfileHandler(db))
+	authed.POST("/user/me/avatar", game.UploadAvatarHandler(db, cfg))
+	authed.GET("/user/identities", game.GetIdentitiesHandler(db))
+	authed.POST("/user/link/:provider", game.LinkIdentityHandler(cfg))
+	authed.DELETE("/user/identities/:identityId", game.UnlinkIdentityHandler(db))
+	authed.POST("/user/merge", game.MergeUsersHandler(db, cfg))
+	authed.GET("/user/invites", game.GetUserInvitesHandler(db))
+	authed.POST("/games/:id/invites", game.CreateInvitesHandler(db, cfg, mailgunNotifier))
+	authed.GET("/games/:id/invites", game.GetGameInvitesHandler(db))
+	authed.POST("/invites/:inviteId/accept", game.AcceptInviteHandler(db, cfg, sseManager))
+	authed.POST("/invites/:inviteId/decline", game.DeclineInviteHandler(db, cfg))
+	authed.PUT("/games/:id/lobby", game.SetLobbyHandler(db, sseManager))
+	authed.GET("/games/:id/join-requests", game.GetJoinRequestsHandler(db))
+	authed.POST("/games/:id/join-requests/:requestId/approve", game.ApproveJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/join-requests/:requestId/reject", game.RejectJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/spectate", game.SpectateGameHandler(db, cfg, sseManager))
+	authed.POST("/games/:id/leave", game.LeaveGameHandler(db, sseManager, mailgunNotifier))
+	authed.DELETE("/games/:id/players/:playerId", game.KickPlayerHandler(db, sseManager, mailgunNotifier))
+	authed.PUT("/games/:id/turn-order", game.ReorderPlayersHandler(db, sseManager))
+	authed.PUT("/games/:id/players/:playerId/role", game.SetPlayerRoleHandler(db, sseManager))
+	authed.POST("/games/:id/transfer-ownership", game.TransferOwnershipHandler(db, sseManager))
+	authed.POST("/games/:id/start", game.StartGameHandler(db, sseManager, mailgunNotifier))
+	authed.POST("/games/:id/pause", game.PauseGameHandler(db, sseManager, mailgunNotifier))
+	authed.POST("/games/:id/resume", game.ResumeGameHandler(db, sseManager, mailgunNotifier))
+	authed.POST("/games/:id/finish", game.FinishGameHandler(db, sseManager, mailgunNotifier))
+	authed.POST("/games/:id/archive", game.ArchiveGameHandler(db, sseManager, mailgunNotifier))
+	authed.POST("/games/:id/players/:playerId/substitute", game.SubstitutePlayerHandler(db, sseManager, mailgunNotifier))
+	authed.PUT("/games/:id/away", game.SetAwayHandler(db, sseManager, mailgunNotifier))
+	authed.DELETE("/games/:id/away", game.ClearAwayHandler(db, sseManager))
 	authed.DELETE("/games/:id", game.DeleteGameHandler(db))
-	r.GET("/games/:id", game.GetGameHandler(db))
+	authed.POST("/games/:id/restore", game.RestoreGameHandler(db, cfg))
+	authed.PATCH("/games/:id", game.UpdateGameHandler(db, sseManager))
+	authed.GET("/games/:id/events", game.GetGameEventsHandler(db))
+	authed.GET("/games/:id/stats", game.GetGameStatsHandler(db))
+	authed.GET("/games/:id/messages", game.GetMessagesHandler(db))
+	authed.POST("/games/:id/messages", messageRateLimit, game.MessageHandler(db, sseManager, mailgunNotifier))
+	authed.PATCH("/games/:id/messages/:messageId", messageRateLimit, game.EditMessageHandler(db, sseManager, mailgunNotifier))
+	authed.DELETE("/games/:id/messages/:messageId", game.DeleteMessageHandler(db, sseManager))
+	authed.PUT("/games/:id/messages/read", game.MarkMessagesReadHandler(db, sseManager))
+	authed.POST("/games/:id/messages/:messageId/reactions", messageRateLimit, game.AddReactionHandler(db, sseManager))
+	authed.DELETE("/games/:id/messages/:messageId/reactions/:emoji", game.RemoveReactionHandler(db, sseManager))
+	r.GET("/games/:id", game.AuthMiddleware(db, cfg), game.GetGameHandler(db, cfg))
+	r.GET("/users/:id/avatar", game.GetAvatarHandler(db))
 
 	// Create rate limited upload endpoint
 	savesGroup := r.Group("/games/:id/saves")
-	savesGroup.Use(game.AuthMiddleware(cfg))
-	savesGroup.Use(game.RateLimitMiddleware(10, time.Minute)) // 10 requests per minute
+	savesGroup.Use(game.AuthMiddleware(db, cfg))
+	savesGroup.Use(game.RateLimitMiddleware(cfg.SaveRateLimit, cfg.SaveRateWindow, cfg.RateLimitIdleExpiry))
 	savesGroup.POST("", game.UploadSaveHandler(db, sseManager, mailgunNotifier))
 
+	savesGroup.GET("", game.GetSavesHandler(db))
 	savesGroup.GET("/latest", game.GetLatestSaveHandler(db))
 
 	msgGroup := r.Group("games/:id/broadcast")
-	msgGroup.Use(game.AuthMiddleware(cfg))
-	msgGroup.Use(game.RateLimitMiddleware(100, time.Minute)) // 10 requests per minute
-	msgGroup.POST("", game.MessageHandler(db, sseManager))
+	msgGroup.Use(game.AuthMiddleware(db, cfg))
+	msgGroup.Use(messageRateLimit)
+	msgGroup.POST("", game.MessageHandler(db, sseManager, mailgunNotifier))
 
 	// Start the server
 	r.Run() // listens and serves on 0.0.0.0:8080 by default
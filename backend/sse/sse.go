@@ -10,22 +10,23 @@ import (
 
 // SSEManager manages SSE connections and broadcasts messages.
 type SSEManager struct {
-	clients   map[chan string]bool
+	// clients maps each connection to the user it belongs to.
+	clients   map[chan string]string
 	clientsMu sync.RWMutex
 }
 
 // NewSSEManager creates a new SSEManager.
 func NewSSEManager() *SSEManager {
 	return &SSEManager{
-		clients: make(map[chan string]bool),
+		clients: make(map[chan string]string),
 	}
 }
 
-// AddClient adds a new client to the SSE manager.
-func (sm *SSEManager) AddClient(client chan string) {
+// AddClient adds a new client for userID to the SSE manager.
+func (sm *SSEManager) AddClient(client chan string, userID string) {
 	sm.clientsMu.Lock()
 	defer sm.clientsMu.Unlock()
-	sm.clients[client] = true
+	sm.clients[client] = userID
 	log.Println("Client added. Total clients:", len(sm.clients))
 }
 
@@ -38,20 +39,60 @@ func (sm *SSEManager) RemoveClient(client chan string) {
 	log.Println("Client removed. Total clients:", len(sm.clients))
 }
 
+// Event is a message that was broadcast earlier and can be sent again to a
+// client that missed it.
+type Event struct {
+	ID   string
+	Type string
+	Data interface{}
+}
+
+// format renders a message in the event stream format. The id line is left
+// out when id is empty.
+func format(id, eventType string, data interface{}) (string, error) {
+	messageBytes, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	message := ""
+	if id != "" {
+		message = "id: " + id + "\n"
+	}
+	return message + "event: " + eventType + "\n" + "data: " + string(messageBytes) + "\n\n", nil
+}
+
 // BroadcastMessage sends a message to all connected clients.
 func (sm *SSEManager) BroadcastMessage(eventType string, data interface{}) {
+	sm.send(nil, "", eventType, data)
+}
+
+// SendEvent sends a message to the clients of the given users only. A
+// non-empty id is sent along; browsers send the last ID they saw as
+// Last-Event-ID when they reconnect.
+func (sm *SSEManager) SendEvent(userIDs []string, id, eventType string, data interface{}) {
+	recipients := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		recipients[userID] = true
+	}
+	sm.send(recipients, id, eventType, data)
+}
+
+// send delivers a message to the clients of recipients, or to every client if
+// recipients is nil.
+func (sm *SSEManager) send(recipients map[string]bool, id, eventType string, data interface{}) {
 	sm.clientsMu.RLock()
 	defer sm.clientsMu.RUnlock()
 
-	messageBytes, err := json.Marshal(data)
+	formattedMessage, err := format(id, eventType, data)
 	if err != nil {
 		log.Printf("Error marshalling SSE data: %v", err)
 		return
 	}
 
-	formattedMessage := "event: " + eventType + "\n" + "data: " + string(messageBytes) + "\n\n"
-
-	for client := range sm.clients {
+	for client, userID := range sm.clients {
+		if recipients != nil && !recipients[userID] {
+			continue
+		}
 		select {
 		case client <- formattedMessage:
 		default:
@@ -63,7 +104,8 @@ func (sm *SSEManager) BroadcastMessage(eventType string, data interface{}) {
 // Broadcaster defines the interface for broadcasting SSE messages.
 type Broadcaster interface {
 	BroadcastMessage(eventType string, data interface{})
-	AddClient(client chan string)
+	SendEvent(userIDs []string, id, eventType string, data interface{})
+	AddClient(client chan string, userID string)
 	RemoveClient(client chan string)
 	Run()
 }
@@ -74,20 +116,40 @@ func (sm *SSEManager) Run() {
 	// For now, it does nothing.
 }
 
-// ServeSSE handles SSE connections.
-func ServeSSE(sm Broadcaster, c *gin.Context) {
+// ServeSSE handles the SSE connection of userID. If replay is given, a client
+// that reconnects with a Last-Event-ID header (or ?last_event_id=) is first
+// sent the events it missed.
+func ServeSSE(sm Broadcaster, c *gin.Context, userID string, replay func(lastEventID string) []Event) {
 	clientChan := make(chan string)
-	sm.AddClient(clientChan)
+	sm.AddClient(clientChan, userID)
 	defer sm.RemoveClient(clientChan)
 
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Initial connection message
 	c.Writer.Flush()
 
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if replay != nil && lastEventID != "" {
+		for _, event := range replay(lastEventID) {
+			msg, err := format(event.ID, event.Type, event.Data)
+			if err != nil {
+				log.Printf("Error marshalling SSE data: %v", err)
+				continue
+			}
+			if _, err := c.Writer.WriteString(msg); err != nil {
+				log.Printf("Error writing to SSE client: %v", err)
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+
 	for {
 		select {
 		case msg, ok := <-clientChan:
@@ -0,0 +1,218 @@
+package away
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func uploadSave(t *testing.T, r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func awayBody(until time.Time, substitute *uuid.UUID) string {
+	req := map[string]interface{}{"until": until.Format(time.RFC3339)}
+	if substitute != nil {
+		req["substitute_user_id"] = substitute.String()
+	}
+	body, _ := json.Marshal(req)
+	return string(body)
+}
+
+func TestAwayPlayersAreSkippedOrCovered(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	token := func(u *game.User) string {
+		tok, err := tests.GetTestUserToken(u.ID, u.Email, cfg)
+		require.NoError(t, err)
+		return tok
+	}
+	host, _ := tests.CreateTestUser(db, "away-host@example.com")
+	middle, _ := tests.CreateTestUser(db, "away-middle@example.com")
+	last, _ := tests.CreateTestUser(db, "away-last@example.com")
+	watcher, _ := tests.CreateTestUser(db, "away-watcher@example.com")
+
+	g := game.Game{Name: "Away Game - " + uuid.New().String(), CreatorID: host.ID, Status: game.GameStatusActive}
+	require.NoError(t, db.Create(&g).Error)
+	hostSeat := game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}
+	middleSeat := game.Player{UserID: middle.ID, GameID: g.ID, TurnOrder: 1}
+	lastSeat := game.Player{UserID: last.ID, GameID: g.ID, TurnOrder: 2}
+	for _, p := range []*game.Player{&hostSeat, &middleSeat, &lastSeat} {
+		require.NoError(t, db.Create(p).Error)
+	}
+	require.NoError(t, db.Create(&game.Player{UserID: watcher.ID, GameID: g.ID, Role: game.RoleSpectator}).Error)
+	require.NoError(t, db.Model(&g).Update("current_turn_id", hostSeat.ID).Error)
+	base := "/api/games/" + g.ID.String()
+	currentTurn := func() uuid.UUID {
+		require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+		return *g.CurrentTurnID
+	}
+
+	until := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second)
+	outsider := uuid.New()
+	assert.Equal(t, http.StatusBadRequest, send(r, "PUT", base+"/away", token(middle), awayBody(time.Now().Add(-time.Hour), nil)).Code)
+	assert.Equal(t, http.StatusBadRequest, send(r, "PUT", base+"/away", token(middle), awayBody(until, &outsider)).Code)
+	assert.Equal(t, http.StatusConflict, send(r, "PUT", base+"/away", token(watcher), awayBody(until, nil)).Code)
+
+	// The middle player goes away without a substitute and is shown as away.
+	w := send(r, "PUT", base+"/away", token(middle), awayBody(until, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send(r, "GET", "/games/"+g.ID.String(), token(host), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var details game.GameResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	for _, p := range details.Players {
+		if p.ID == middleSeat.ID {
+			require.NotNil(t, p.AwayUntil)
+			assert.True(t, until.Equal(*p.AwayUntil))
+		} else {
+			assert.Nil(t, p.AwayUntil)
+		}
+	}
+
+	// Nobody can leave the game to someone who is away themselves.
+	assert.Equal(t, http.StatusBadRequest, send(r, "PUT", base+"/away", token(last), awayBody(until, &middle.ID)).Code)
+
+	// Their turn is skipped.
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(host)).Code)
+	assert.Equal(t, lastSeat.ID, currentTurn())
+	assert.Equal(t, last.Email, mockNotifier.LastRecipientEmail)
+
+	// The last player goes away while holding the turn, leaving the watcher
+	// to cover, who is told at once.
+	*mockNotifier = tests.MockNotifier{}
+	w = send(r, "PUT", base+"/away", token(last), awayBody(until, &watcher.ID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, lastSeat.ID, currentTurn(), "the seat keeps the turn")
+	assert.Equal(t, watcher.Email, mockNotifier.LastRecipientEmail)
+
+	// The substitute uploads into the away player's seat.
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(watcher)).Code)
+	var save game.Save
+	require.NoError(t, db.Where("game_id = ? AND uploaded_by = ?", g.ID, watcher.ID).First(&save).Error)
+	assert.Equal(t, lastSeat.ID, *save.PlayerID)
+	assert.Equal(t, hostSeat.ID, currentTurn())
+
+	// Next time round the substitute is emailed instead of the away player.
+	*mockNotifier = tests.MockNotifier{}
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(host)).Code)
+	assert.Equal(t, lastSeat.ID, currentTurn())
+	assert.Equal(t, watcher.Email, mockNotifier.LastRecipientEmail)
+
+	// Back from holiday, the middle player is in the rotation again.
+	require.Equal(t, http.StatusOK, send(r, "DELETE", base+"/away", token(middle), "").Code)
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(watcher)).Code)
+	assert.Equal(t, hostSeat.ID, currentTurn())
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(host)).Code)
+	assert.Equal(t, middleSeat.ID, currentTurn())
+}
+
+func TestSubstituteLeavingWhileCovering(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "cover-host@example.com")
+	away, _ := tests.CreateTestUser(db, "cover-away@example.com")
+	helper, _ := tests.CreateTestUser(db, "cover-helper@example.com")
+
+	g := game.Game{Name: "Cover Game - " + uuid.New().String(), CreatorID: host.ID, Status: game.GameStatusActive}
+	require.NoError(t, db.Create(&g).Error)
+	until := time.Now().Add(72 * time.Hour)
+	hostSeat := game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}
+	awaySeat := game.Player{UserID: away.ID, GameID: g.ID, TurnOrder: 1, AwayUntil: &until, AwaySubstituteID: &helper.ID}
+	helperSeat := game.Player{UserID: helper.ID, GameID: g.ID, TurnOrder: 2}
+	for _, p := range []*game.Player{&hostSeat, &awaySeat, &helperSeat} {
+		require.NoError(t, db.Create(p).Error)
+	}
+	require.NoError(t, db.Model(&g).Update("current_turn_id", awaySeat.ID).Error)
+
+	// The helper leaves while covering the turn of the away player.
+	helperToken, err := tests.GetTestUserToken(helper.ID, helper.Email, cfg)
+	require.NoError(t, err)
+	*mockNotifier = tests.MockNotifier{}
+	w := send(r, "POST", "/api/games/"+g.ID.String()+"/leave", helperToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var covered game.Player
+	require.NoError(t, db.First(&covered, "id = ?", awaySeat.ID).Error)
+	assert.Nil(t, covered.AwaySubstituteID)
+
+	var reloaded game.Game
+	require.NoError(t, db.First(&reloaded, "id = ?", g.ID).Error)
+	require.NotNil(t, reloaded.CurrentTurnID)
+	assert.Equal(t, hostSeat.ID, *reloaded.CurrentTurnID, "the uncovered turn is skipped")
+	assert.Equal(t, host.Email, mockNotifier.LastRecipientEmail, "the next player is told it is their turn")
+}
+
+func TestAwayEmailsUseRecipientTimezone(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "tz-host@example.com")
+	away, _ := tests.CreateTestUser(db, "tz-away@example.com")
+	helper, _ := tests.CreateTestUser(db, "tz-helper@example.com")
+	require.NoError(t, db.Model(helper).Updates(map[string]interface{}{"timezone": "Pacific/Auckland", "locale": "en-NZ"}).Error)
+
+	g := game.Game{Name: "Timezone Game - " + uuid.New().String(), CreatorID: host.ID, Status: game.GameStatusActive}
+	require.NoError(t, db.Create(&g).Error)
+	awaySeat := game.Player{UserID: away.ID, GameID: g.ID, TurnOrder: 0}
+	for _, p := range []*game.Player{&awaySeat, {UserID: host.ID, GameID: g.ID, TurnOrder: 1, Role: game.RoleOwner}, {UserID: helper.ID, GameID: g.ID, TurnOrder: 2}} {
+		require.NoError(t, db.Create(p).Error)
+	}
+	require.NoError(t, db.Model(&g).Update("current_turn_id", awaySeat.ID).Error)
+
+	// Late evening in UTC is already the next day in Auckland.
+	now := time.Now().UTC()
+	until := time.Date(now.Year(), now.Month(), now.Day()+10, 22, 0, 0, 0, time.UTC)
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	require.NoError(t, err)
+
+	awayToken, err := tests.GetTestUserToken(away.ID, away.Email, cfg)
+	require.NoError(t, err)
+	w := send(r, "PUT", "/api/games/"+g.ID.String()+"/away", awayToken, awayBody(until, &helper.ID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, helper.Email, mockNotifier.LastRecipientEmail)
+	assert.Contains(t, mockNotifier.LastBody, "until "+until.In(auckland).Format("2 January"))
+}
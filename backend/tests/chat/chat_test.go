@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChat(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	author, _ := tests.CreateTestUser(db, "chat-author@example.com")
+	other, _ := tests.CreateTestUser(db, "chat-other@example.com")
+	outsider, _ := tests.CreateTestUser(db, "chat-outsider@example.com")
+	authorToken, err := tests.GetTestUserToken(author.ID, author.Email, cfg)
+	require.NoError(t, err)
+	otherToken, err := tests.GetTestUserToken(other.ID, other.Email, cfg)
+	require.NoError(t, err)
+	outsiderToken, err := tests.GetTestUserToken(outsider.ID, outsider.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Chat Game - " + uuid.New().String(), CreatorID: author.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: author.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: other.ID, GameID: g.ID, Role: game.RoleSpectator}).Error)
+	base := "/api/games/" + g.ID.String() + "/messages"
+
+	var posted []game.ChatMessageResponse
+	for i := 0; i < 3; i++ {
+		w := send(r, "POST", base, authorToken, fmt.Sprintf(`{"message":"message %d"}`, i))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var m game.ChatMessageResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+		posted = append(posted, m)
+	}
+	assert.Equal(t, "Player "+author.ID.String()[:8], posted[0].Author.DisplayName)
+	assert.Empty(t, posted[0].Author.Email)
+
+	t.Run("validation", func(t *testing.T) {
+		for _, body := range []string{
+			`{"message":"   "}`,
+			`{"message":"` + strings.Repeat("a", 2001) + `"}`,
+			`{"message":"bell\u0007"}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, send(r, "POST", base, authorToken, body).Code)
+		}
+		assert.Equal(t, http.StatusForbidden, send(r, "POST", base, outsiderToken, `{"message":"hi"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send(r, "GET", base, outsiderToken, "").Code)
+	})
+
+	t.Run("history is paginated newest first", func(t *testing.T) {
+		w := send(r, "GET", base+"?limit=2", otherToken, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page game.ChatMessagesPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		require.Len(t, page.Messages, 2)
+		assert.Equal(t, "message 2", page.Messages[0].Body)
+		require.NotEmpty(t, page.NextCursor)
+
+		w = send(r, "GET", base+"?limit=2&before="+page.NextCursor, otherToken, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		page = game.ChatMessagesPage{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		require.Len(t, page.Messages, 1)
+		assert.Equal(t, "message 0", page.Messages[0].Body)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("only the author can edit or delete", func(t *testing.T) {
+		path := base + "/" + posted[1].ID.String()
+		assert.Equal(t, http.StatusForbidden, send(r, "PATCH", path, otherToken, `{"message":"hijacked"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send(r, "DELETE", path, otherToken, "").Code)
+
+		w := send(r, "PATCH", path, authorToken, `{"message":"edited"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var edited game.ChatMessageResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &edited))
+		assert.Equal(t, "edited", edited.Body)
+		assert.NotNil(t, edited.EditedAt)
+
+		require.Equal(t, http.StatusOK, send(r, "DELETE", path, authorToken, "").Code)
+		w = send(r, "GET", base, authorToken, "")
+		var page game.ChatMessagesPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Len(t, page.Messages, 2)
+	})
+}
+
+func TestChatEvent(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	sseManager := &tests.MockSSEManager{}
+	r := gin.New()
+	r.POST("/games/:id/messages", game.AuthMiddleware(db, cfg), game.MessageHandler(db, sseManager, tests.NewMockNotifier()))
+
+	user, _ := tests.CreateTestUser(db, "chat-event@example.com")
+	require.NoError(t, db.Model(user).Update("display_name", "Caesar").Error)
+	token, err := tests.GetTestUserToken(user.ID, user.Email, cfg)
+	require.NoError(t, err)
+	g := game.Game{Name: "Chat Event Game - " + uuid.New().String(), CreatorID: user.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: user.ID, GameID: g.ID, Role: game.RoleOwner}).Error)
+	watcher, _ := tests.CreateTestUser(db, "chat-event-watcher@example.com")
+	require.NoError(t, db.Create(&game.Player{UserID: watcher.ID, GameID: g.ID, Role: game.RoleSpectator}).Error)
+	tests.CreateTestUser(db, "chat-event-outsider@example.com")
+
+	w := send(r, "POST", "/games/"+g.ID.String()+"/messages", token, `{"message":"veni, vidi"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	assert.Equal(t, "chat_message", sseManager.LastEvent)
+	assert.ElementsMatch(t, []string{user.ID.String(), watcher.ID.String()}, sseManager.LastRecipients, "chat only goes to members")
+	event, ok := sseManager.LastData.(game.ChatMessageResponse)
+	require.True(t, ok)
+	assert.Equal(t, user.ID, event.Author.ID)
+	assert.Equal(t, "Caesar", event.Author.DisplayName)
+	assert.Equal(t, "veni, vidi", event.Body)
+	assert.NotEqual(t, uuid.Nil, event.ID)
+}
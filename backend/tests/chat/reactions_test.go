@@ -0,0 +1,211 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMentions(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	author, _ := tests.CreateTestUser(db, "mention-author@example.com")
+	friend, _ := tests.CreateTestUser(db, "mention-friend@example.com")
+	require.NoError(t, db.Model(author).Update("display_name", "Ada").Error)
+	require.NoError(t, db.Model(friend).Update("display_name", "Grace Hopper").Error)
+	authorToken, err := tests.GetTestUserToken(author.ID, author.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Mention Game - " + uuid.New().String(), CreatorID: author.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: author.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: friend.ID, GameID: g.ID, TurnOrder: 1}).Error)
+	base := "/api/games/" + g.ID.String() + "/messages"
+
+	// Neither an email address nor a longer name counts as a mention.
+	w := send(r, "POST", base, authorToken, `{"message":"mail mention-friend@example.com or @GraceHoppers"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var message game.ChatMessageResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Empty(t, message.Mentions)
+	assert.Empty(t, mockNotifier.LastRecipientEmail)
+
+	w = send(r, "POST", base, authorToken, `{"message":"your turn @gracehopper!"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, []uuid.UUID{friend.ID}, message.Mentions)
+	assert.Equal(t, friend.Email, mockNotifier.LastRecipientEmail)
+	assert.Equal(t, "Ada mentioned you in "+g.Name, mockNotifier.LastSubject)
+	assert.Contains(t, mockNotifier.LastBody, "your turn @gracehopper!")
+
+	// Editing only notifies people who were not mentioned before.
+	*mockNotifier = tests.MockNotifier{}
+	w = send(r, "PATCH", base+"/"+message.ID.String(), authorToken, `{"message":"really, your turn @mention-friend"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, mockNotifier.LastRecipientEmail)
+
+	// Authors do not mention themselves.
+	w = send(r, "POST", base, authorToken, `{"message":"note to self @ada"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Empty(t, mockNotifier.LastRecipientEmail)
+}
+
+func TestReactions(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	author, _ := tests.CreateTestUser(db, "react-author@example.com")
+	other, _ := tests.CreateTestUser(db, "react-other@example.com")
+	outsider, _ := tests.CreateTestUser(db, "react-outsider@example.com")
+	authorToken, err := tests.GetTestUserToken(author.ID, author.Email, cfg)
+	require.NoError(t, err)
+	otherToken, err := tests.GetTestUserToken(other.ID, other.Email, cfg)
+	require.NoError(t, err)
+	outsiderToken, err := tests.GetTestUserToken(outsider.ID, outsider.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Reaction Game - " + uuid.New().String(), CreatorID: author.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: author.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: other.ID, GameID: g.ID, TurnOrder: 1}).Error)
+	base := "/api/games/" + g.ID.String() + "/messages"
+
+	w := send(r, "POST", base, authorToken, `{"message":"gg"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var message game.ChatMessageResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	reactions := base + "/" + message.ID.String() + "/reactions"
+
+	assert.Equal(t, http.StatusCreated, send(r, "POST", reactions, authorToken, `{"emoji":"👍"}`).Code)
+	assert.Equal(t, http.StatusCreated, send(r, "POST", reactions, otherToken, `{"emoji":"👍"}`).Code)
+	assert.Equal(t, http.StatusOK, send(r, "POST", reactions, otherToken, `{"emoji":"👍"}`).Code, "reacting twice is a no-op")
+	assert.Equal(t, http.StatusCreated, send(r, "POST", reactions, otherToken, `{"emoji":"🎉"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(r, "POST", reactions, otherToken, `{"emoji":"lol"}`).Code)
+	assert.Equal(t, http.StatusForbidden, send(r, "POST", reactions, outsiderToken, `{"emoji":"👍"}`).Code)
+
+	var page game.ChatMessagesPage
+	w = send(r, "GET", base, otherToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Messages, 1)
+	require.Len(t, page.Messages[0].Reactions, 2)
+	assert.Equal(t, "👍", page.Messages[0].Reactions[0].Emoji)
+	assert.Equal(t, 2, page.Messages[0].Reactions[0].Count)
+	assert.Equal(t, []uuid.UUID{other.ID}, page.Messages[0].Reactions[1].UserIDs)
+
+	assert.Equal(t, http.StatusOK, send(r, "DELETE", reactions+"/"+url.PathEscape("🎉"), otherToken, "").Code)
+	assert.Equal(t, http.StatusNotFound, send(r, "DELETE", reactions+"/"+url.PathEscape("🎉"), otherToken, "").Code)
+
+	page = game.ChatMessagesPage{}
+	w = send(r, "GET", base, otherToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Messages[0].Reactions, 1)
+}
+
+func TestReadCursor(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	author, _ := tests.CreateTestUser(db, "read-author@example.com")
+	reader, _ := tests.CreateTestUser(db, "read-reader@example.com")
+	authorToken, err := tests.GetTestUserToken(author.ID, author.Email, cfg)
+	require.NoError(t, err)
+	readerToken, err := tests.GetTestUserToken(reader.ID, reader.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Read Game - " + uuid.New().String(), CreatorID: author.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: author.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: reader.ID, GameID: g.ID, TurnOrder: 1}).Error)
+	base := "/api/games/" + g.ID.String() + "/messages"
+
+	var ids []string
+	for _, text := range []string{"one", "two", "three"} {
+		w := send(r, "POST", base, authorToken, `{"message":"`+text+`"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var m game.ChatMessageResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+		ids = append(ids, m.ID.String())
+	}
+
+	unread := func(token string) int64 {
+		w := send(r, "GET", "/api/user/games", token, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var games []game.GameResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &games))
+		require.Len(t, games, 1)
+		return games[0].UnreadMessages
+	}
+
+	assert.Equal(t, int64(3), unread(readerToken))
+	assert.Equal(t, int64(0), unread(authorToken), "your own messages are never unread")
+
+	w := send(r, "PUT", base+"/read", readerToken, `{"message_id":"`+ids[1]+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, int64(1), unread(readerToken))
+
+	// The cursor does not move backwards.
+	w = send(r, "PUT", base+"/read", readerToken, `{"message_id":"`+ids[0]+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, int64(1), unread(readerToken))
+
+	w = send(r, "GET", "/games/"+g.ID.String(), readerToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var details game.GameResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	assert.Equal(t, int64(1), details.UnreadMessages)
+	for _, p := range details.Players {
+		if p.UserID == reader.ID {
+			require.NotNil(t, p.LastReadMessageID)
+			assert.Equal(t, ids[1], p.LastReadMessageID.String())
+		}
+	}
+
+	assert.Equal(t, http.StatusNotFound,
+		send(r, "PUT", base+"/read", readerToken, `{"message_id":"`+uuid.New().String()+`"}`).Code)
+}
+
+func TestReactionEventsGoToMembers(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	sseManager := &tests.MockSSEManager{}
+	r := gin.New()
+	r.POST("/games/:id/messages/:messageId/reactions", game.AuthMiddleware(db, cfg), game.AddReactionHandler(db, sseManager))
+	r.DELETE("/games/:id/messages/:messageId/reactions/:emoji", game.AuthMiddleware(db, cfg), game.RemoveReactionHandler(db, sseManager))
+
+	user, _ := tests.CreateTestUser(db, "react-event@example.com")
+	member, _ := tests.CreateTestUser(db, "react-event-member@example.com")
+	tests.CreateTestUser(db, "react-event-outsider@example.com")
+	token, err := tests.GetTestUserToken(user.ID, user.Email, cfg)
+	require.NoError(t, err)
+	g := game.Game{Name: "Reaction Event Game - " + uuid.New().String(), CreatorID: user.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: user.ID, GameID: g.ID, Role: game.RoleOwner}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: member.ID, GameID: g.ID, TurnOrder: 1}).Error)
+	message := game.ChatMessage{GameID: g.ID, AuthorID: member.ID, Body: "gg"}
+	require.NoError(t, db.Create(&message).Error)
+	reactions := "/games/" + g.ID.String() + "/messages/" + message.ID.String() + "/reactions"
+	members := []string{user.ID.String(), member.ID.String()}
+
+	require.Equal(t, http.StatusCreated, send(r, "POST", reactions, token, `{"emoji":"👍"}`).Code)
+	assert.Equal(t, "chat_reaction_added", sseManager.LastEvent)
+	assert.ElementsMatch(t, members, sseManager.LastRecipients)
+
+	require.Equal(t, http.StatusOK, send(r, "DELETE", reactions+"/"+url.PathEscape("👍"), token, "").Code)
+	assert.Equal(t, "chat_reaction_removed", sseManager.LastEvent)
+	assert.ElementsMatch(t, members, sseManager.LastRecipients)
+}
@@ -0,0 +1,165 @@
+package clock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// createClockGame creates an active two-player game with a one hour time bank
+// in which the first player has used 50 minutes and has been on their turn
+// for another 20.
+func createClockGame(t *testing.T, db *gorm.DB, penalty string) (game.Game, game.Player, game.Player) {
+	host, _ := tests.CreateTestUser(db, "clock-host-"+uuid.New().String()+"@example.com")
+	rival, _ := tests.CreateTestUser(db, "clock-rival-"+uuid.New().String()+"@example.com")
+
+	startedAt := time.Now().Add(-20 * time.Minute)
+	g := game.Game{
+		Name:          "Clock Game - " + uuid.New().String(),
+		CreatorID:     host.ID,
+		Status:        game.GameStatusActive,
+		TurnStartedAt: &startedAt,
+		Settings:      game.GameSettings{TimeBankHours: 1, TimeBankPenalty: penalty},
+	}
+	require.NoError(t, db.Create(&g).Error)
+	hostSeat := game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner, TimeUsedSeconds: 50 * 60}
+	rivalSeat := game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}
+	require.NoError(t, db.Create(&hostSeat).Error)
+	require.NoError(t, db.Create(&rivalSeat).Error)
+	require.NoError(t, db.Model(&g).Update("current_turn_id", hostSeat.ID).Error)
+	g.CurrentTurnID = &hostSeat.ID
+	return g, hostSeat, rivalSeat
+}
+
+func TestGameDetailsShowTimeRemaining(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	g, hostSeat, rivalSeat := createClockGame(t, db, game.ClockPenaltyNotify)
+	var host game.User
+	require.NoError(t, db.First(&host, "id = ?", hostSeat.UserID).Error)
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/games/"+g.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var details game.GameResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	require.NotNil(t, details.TurnStartedAt)
+	remaining := map[uuid.UUID]int64{}
+	for _, p := range details.Players {
+		require.NotNil(t, p.TimeRemainingSeconds)
+		remaining[p.ID] = *p.TimeRemainingSeconds
+	}
+	assert.Equal(t, int64(0), remaining[hostSeat.ID], "the bank never goes negative")
+	assert.Equal(t, int64(60*60), remaining[rivalSeat.ID])
+}
+
+func TestSkipPenaltyPassesTheTurn(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	mockSSE := &tests.MockSSEManager{}
+	mockNotifier := tests.NewMockNotifier()
+	g, hostSeat, rivalSeat := createClockGame(t, db, game.ClockPenaltySkip)
+
+	penalised, err := game.EnforceTimeBanks(db, mockSSE, mockNotifier)
+	require.NoError(t, err)
+	assert.Equal(t, 1, penalised)
+
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.Equal(t, rivalSeat.ID, *g.CurrentTurnID)
+	assert.Equal(t, game.GameStatusActive, g.Status)
+	require.NotNil(t, g.TurnStartedAt, "the clock runs for the next player")
+
+	require.NoError(t, db.First(&hostSeat, "id = ?", hostSeat.ID).Error)
+	assert.NotNil(t, hostSeat.OutOfTimeAt)
+	assert.InDelta(t, 70*60, hostSeat.TimeUsedSeconds, 5)
+	assert.Equal(t, "turn_changed", mockSSE.LastEvent)
+	assert.NotEmpty(t, mockNotifier.LastRecipientEmail)
+
+	// The rival still has time left.
+	penalised, err = game.EnforceTimeBanks(db, mockSSE, mockNotifier)
+	require.NoError(t, err)
+	assert.Equal(t, 0, penalised)
+}
+
+func TestPenaltyYieldsToAConcurrentUpload(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	g, hostSeat, rivalSeat := createClockGame(t, db, game.ClockPenaltySkip)
+
+	// The host uploads just after the clock job found them out of time.
+	callback := "test:upload_first"
+	require.NoError(t, db.Callback().Update().Before("gorm:update").Register(callback, func(tx *gorm.DB) {
+		tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec("UPDATE games SET current_turn_id = ? WHERE id = ?", rivalSeat.ID, g.ID)
+	}))
+	penalised, err := game.EnforceTimeBanks(db, &tests.MockSSEManager{}, tests.NewMockNotifier())
+	require.NoError(t, db.Callback().Update().Remove(callback))
+	require.NoError(t, err)
+	assert.Equal(t, 0, penalised)
+
+	var reloaded game.Game
+	require.NoError(t, db.First(&reloaded, "id = ?", g.ID).Error)
+	assert.Equal(t, rivalSeat.ID, *reloaded.CurrentTurnID, "the rival's turn is not skipped")
+	require.NoError(t, db.First(&hostSeat, "id = ?", hostSeat.ID).Error)
+	assert.Nil(t, hostSeat.OutOfTimeAt)
+}
+
+func TestPausePenaltyStopsTheClock(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	mockSSE := &tests.MockSSEManager{}
+	g, hostSeat, _ := createClockGame(t, db, game.ClockPenaltyPause)
+
+	penalised, err := game.EnforceTimeBanks(db, mockSSE, tests.NewMockNotifier())
+	require.NoError(t, err)
+	assert.Equal(t, 1, penalised)
+
+	var paused game.Game
+	require.NoError(t, db.First(&paused, "id = ?", g.ID).Error)
+	assert.Equal(t, game.GameStatusPaused, paused.Status)
+	assert.Equal(t, hostSeat.ID, *paused.CurrentTurnID)
+	assert.Nil(t, paused.TurnStartedAt)
+
+	// Once resumed, the player who ran out is not penalised again.
+	require.NoError(t, db.Model(&g).Updates(map[string]interface{}{
+		"status":          game.GameStatusActive,
+		"turn_started_at": time.Now().Add(-time.Hour),
+	}).Error)
+	penalised, err = game.EnforceTimeBanks(db, mockSSE, tests.NewMockNotifier())
+	require.NoError(t, err)
+	assert.Equal(t, 0, penalised)
+}
+
+func TestClockJobDisabled(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		assert.NotPanics(t, func() {
+			stop := game.StartClockJob(db, &tests.MockSSEManager{}, tests.NewMockNotifier(), interval)
+			stop()
+		})
+	}
+}
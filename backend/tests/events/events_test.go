@@ -0,0 +1,197 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func uploadSave(t *testing.T, r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func eventTypes(page game.GameEventsPage) []string {
+	types := make([]string, 0, len(page.Events))
+	for _, e := range page.Events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestActivityFeed(t *testing.T) {
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, tests.NewMockNotifier())
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	host, _ := tests.CreateTestUser(db, "events-host@example.com")
+	guest, _ := tests.CreateTestUser(db, "events-guest@example.com")
+	outsider, _ := tests.CreateTestUser(db, "events-outsider@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	guestToken, err := tests.GetTestUserToken(guest.ID, guest.Email, cfg)
+	require.NoError(t, err)
+	outsiderToken, err := tests.GetTestUserToken(outsider.ID, outsider.Email, cfg)
+	require.NoError(t, err)
+
+	w := send(r, "POST", "/create-game", hostToken, `{"name":"Events Game","join_policy":"open"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created struct {
+		GameID uuid.UUID `json:"game_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	base := "/api/games/" + created.GameID.String()
+
+	require.Equal(t, http.StatusOK, send(r, "POST", "/join-game/"+created.GameID.String(), guestToken, "").Code)
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, created.GameID, hostToken).Code)
+	require.Equal(t, http.StatusCreated, send(r, "POST", base+"/messages", guestToken, `{"message":"secret plans"}`).Code)
+
+	w = send(r, "GET", base+"/events", guestToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var page game.GameEventsPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []string{"message_posted", "new_save", "turn_changed", "game_status_changed", "player_joined", "game_created"}, eventTypes(page))
+	require.NotNil(t, page.Events[0].Actor)
+	assert.Equal(t, guest.ID, page.Events[0].Actor.ID)
+	assert.NotContains(t, string(page.Events[0].Data), "secret plans", "chat text stays out of the log")
+	assert.Empty(t, page.NextCursor)
+
+	// Paging walks back through the same timeline.
+	w = send(r, "GET", base+"/events?limit=4", guestToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = game.GameEventsPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Events, 4)
+	require.NotEmpty(t, page.NextCursor)
+	w = send(r, "GET", base+"/events?limit=4&before="+page.NextCursor, guestToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = game.GameEventsPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []string{"player_joined", "game_created"}, eventTypes(page))
+
+	assert.Equal(t, http.StatusForbidden, send(r, "GET", base+"/events", outsiderToken, "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(r, "GET", base+"/events?limit=0", guestToken, "").Code)
+
+	// Closing the lobby and turning down a join request are logged too.
+	require.Equal(t, http.StatusOK, send(r, "PUT", base+"/lobby", hostToken, `{"open":false}`).Code)
+	w = send(r, "POST", "/join-game/"+created.GameID.String(), outsiderToken, "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var joinRequest struct {
+		RequestID string `json:"request_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &joinRequest))
+	require.Equal(t, http.StatusOK, send(r, "POST", base+"/join-requests/"+joinRequest.RequestID+"/reject", hostToken, "").Code)
+
+	w = send(r, "GET", base+"/events?limit=3", guestToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = game.GameEventsPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []string{"join_request_rejected", "join_requested", "lobby_changed"}, eventTypes(page))
+	require.NotNil(t, page.Events[0].Actor)
+	assert.Equal(t, host.ID, page.Events[0].Actor.ID)
+
+	require.Equal(t, http.StatusOK, send(r, "DELETE", base, hostToken, "").Code)
+	var deleted game.GameEvent
+	require.NoError(t, db.Where("game_id = ? AND type = ?", created.GameID, "game_deleted").First(&deleted).Error)
+	assert.Equal(t, host.ID, *deleted.ActorID)
+}
+
+func TestReplayEvents(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	sseManager := &tests.MockSSEManager{}
+	r := gin.New()
+	r.PATCH("/games/:id", game.AuthMiddleware(db, cfg), game.UpdateGameHandler(db, sseManager))
+
+	host, _ := tests.CreateTestUser(db, "replay-host@example.com")
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	g := game.Game{Name: "Replay Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, Role: game.RoleOwner}).Error)
+
+	var ids []string
+	for _, name := range []string{"First", "Second", "Third"} {
+		w := send(r, "PATCH", "/games/"+g.ID.String(), token, `{"name":"`+name+`"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "game_updated", sseManager.LastEvent)
+		require.NotEmpty(t, sseManager.LastEventID, "broadcasts carry the event ID")
+		ids = append(ids, sseManager.LastEventID)
+	}
+
+	assert.Equal(t, []string{host.ID.String()}, sseManager.LastRecipients, "only members are sent game events")
+
+	replay := game.ReplayEvents(db, host.ID)
+	missed := replay(ids[0])
+	require.Len(t, missed, 2)
+	assert.Equal(t, ids[1], missed[0].ID)
+	assert.Equal(t, ids[2], missed[1].ID)
+	assert.Equal(t, "game_updated", missed[0].Type)
+
+	assert.Empty(t, replay(ids[2]))
+	assert.Empty(t, replay("not-an-id"))
+
+	// Someone outside the game gets nothing, even holding an event ID.
+	outsider, _ := tests.CreateTestUser(db, "replay-outsider@example.com")
+	assert.Empty(t, game.ReplayEvents(db, outsider.ID)(ids[0]))
+
+	// Nor do members once the game is deleted.
+	require.NoError(t, db.Delete(&g).Error)
+	assert.Empty(t, replay(ids[0]))
+}
+
+func TestNotificationsRequireLogin(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	r := gin.New()
+	r.GET("/sse/notifications", game.TokenQueryMiddleware(), game.AuthMiddleware(db, cfg), game.NotificationsHandler(db, &tests.MockSSEManager{}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/sse/notifications?last_event_id="+uuid.New().String(), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/sse/notifications?token=forged", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -8,6 +8,7 @@ import (
 	"panzerstadt/async-multiplayer/game"
 	"panzerstadt/async-multiplayer/tests"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -48,24 +49,66 @@ func TestDeleteGame(t *testing.T) {
 	// 6. Assert the results
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	// Check that the game is deleted
+	// Check that the game is hidden
 	var deletedGame game.Game
 	err = db.First(&deletedGame, "id = ?", gameToCreate.ID).Error
 	assert.Error(t, err, "game should be deleted")
 
-	// Check that the player is deleted
+	// Players, saves and files are kept for the grace period
+	var keptPlayer game.Player
+	assert.NoError(t, db.First(&keptPlayer, "game_id = ?", gameToCreate.ID).Error, "player should be kept")
+	var keptSave game.Save
+	assert.NoError(t, db.First(&keptSave, "game_id = ?", gameToCreate.ID).Error, "save record should be kept")
+	_, err = os.Stat(saveFilePath)
+	assert.NoError(t, err, "save file should be kept")
+
+	// 7. Purging after the grace period removes everything
+	purged, err := game.PurgeDeletedGames(db, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	err = db.Unscoped().First(&deletedGame, "id = ?", gameToCreate.ID).Error
+	assert.Error(t, err, "game should be purged")
+
 	var deletedPlayer game.Player
 	err = db.First(&deletedPlayer, "game_id = ?", gameToCreate.ID).Error
 	assert.Error(t, err, "player should be deleted")
 
-	// Check that the save record is deleted
 	var deletedSave game.Save
 	err = db.First(&deletedSave, "game_id = ?", gameToCreate.ID).Error
 	assert.Error(t, err, "save record should be deleted")
 
-	// Check that the save file is deleted
 	_, err = os.Stat(saveFilePath)
 	assert.True(t, os.IsNotExist(err), "save file should be deleted")
+	os.RemoveAll(saveDir)
+}
+
+func TestRestoreGame(t *testing.T) {
+	db, router, cfg, err := tests.SetupTestEnvironment()
+	assert.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "restorer@test.com")
+	assert.NoError(t, err)
+	gameToRestore := game.Game{Name: "GameToRestore", CreatorID: creator.ID}
+	db.Create(&gameToRestore)
+	token, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("DELETE", "/api/games/"+gameToRestore.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "/api/games/"+gameToRestore.ID.String()+"/restore", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var restored game.Game
+	assert.NoError(t, db.First(&restored, "id = ?", gameToRestore.ID).Error, "game should be visible again")
 }
 
 func TestDeleteGame_NotCreator(t *testing.T) {
@@ -94,3 +137,16 @@ func TestDeleteGame_NotCreator(t *testing.T) {
 	// 4. Assert the results
 	assert.Equal(t, http.StatusForbidden, w.Code)
 }
+
+func TestPurgeJobDisabled(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	assert.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		assert.NotPanics(t, func() {
+			stop := game.StartPurgeJob(db, interval, time.Hour)
+			stop()
+		})
+	}
+}
@@ -10,66 +10,75 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"gorm.io/gorm"
 
 	"panzerstadt/async-multiplayer/game"
 	"panzerstadt/async-multiplayer/tests"
 )
 
-func setupRouter(db *gorm.DB) *gin.Engine {
-	r := gin.Default()
-	r.GET("/games/:id", game.GetGameHandler(db))
-	return r
+func getGame(r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/games/"+gameID.String(), nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	return w
 }
 
 func TestGetGame(t *testing.T) {
-	_, _, cfg, err := tests.SetupTestEnvironment()
+	db, r, cfg, err := tests.SetupTestEnvironment()
 	require.NoError(t, err)
-	game.InitOAuth(cfg)
+	defer tests.TeardownTestEnvironment(db)
 
-	t.Run("success", func(t *testing.T) {
-		db := tests.SetupTestDB(t)
-		r := setupRouter(db)
-
-		user := game.User{Email: "creator@example.com"}
-		require.NoError(t, db.Create(&user).Error)
-		newGame := game.Game{Name: "Test Game - " + uuid.New().String(), CreatorID: user.ID}
-		require.NoError(t, db.Create(&newGame).Error)
-
-		player1 := game.Player{UserID: user.ID, GameID: newGame.ID, TurnOrder: 0}
-		require.NoError(t, db.Create(&player1).Error)
-
-		// Re-fetch the game to ensure players are loaded
-		var fetchedGame game.Game
-		require.NoError(t, db.Preload("Players").First(&fetchedGame, "id = ?", newGame.ID).Error)
+	creator, err := tests.CreateTestUser(db, "creator@example.com")
+	require.NoError(t, err)
+	creatorToken, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	require.NoError(t, err)
+	outsider, err := tests.CreateTestUser(db, "outsider@example.com")
+	require.NoError(t, err)
+	outsiderToken, err := tests.GetTestUserToken(outsider.ID, outsider.Email, cfg)
+	require.NoError(t, err)
 
-		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/games/"+fetchedGame.ID.String(), nil)
-		r.ServeHTTP(w, req)
+	newGame := game.Game{Name: "Test Game - " + uuid.New().String(), CreatorID: creator.ID}
+	require.NoError(t, db.Create(&newGame).Error)
+	player1 := game.Player{UserID: creator.ID, GameID: newGame.ID, TurnOrder: 0}
+	require.NoError(t, db.Create(&player1).Error)
+
+	t.Run("members see players with emails", func(t *testing.T) {
+		w := getGame(r, newGame.ID, creatorToken)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response game.GameResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, newGame.ID, response.ID)
+		assert.Equal(t, newGame.Name, response.Name)
+		assert.Equal(t, newGame.CreatorID, response.CreatorID)
+		require.Len(t, response.Players, 1)
+		assert.Equal(t, player1.ID, response.Players[0].ID)
+		assert.Equal(t, "creator", response.Players[0].User.DisplayName)
+		assert.Equal(t, "creator@example.com", response.Players[0].User.Email)
+	})
 
-		assert.Equal(t, http.StatusOK, w.Code)
-		t.Logf("Response Body: %s", w.Body.String())
+	t.Run("outsiders of an open game do not see emails", func(t *testing.T) {
+		w := getGame(r, newGame.ID, outsiderToken)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), "creator@example.com")
+		assert.Contains(t, w.Body.String(), `"display_name":"Player `+creator.ID.String()[:8]+`"`)
+		assert.NotContains(t, w.Body.String(), `"creator"`, "the email local part is not a name either")
+	})
 
-		var responseGame game.Game
-		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseGame))
+	t.Run("outsiders of a private game are rejected", func(t *testing.T) {
+		require.NoError(t, db.Model(&newGame).Update("join_policy", game.JoinPolicyInviteOnly).Error)
+		assert.Equal(t, http.StatusForbidden, getGame(r, newGame.ID, outsiderToken).Code)
+	})
 
-		assert.Equal(t, fetchedGame.ID, responseGame.ID)
-		assert.Equal(t, fetchedGame.Name, responseGame.Name)
-		assert.Equal(t, fetchedGame.CreatorID, responseGame.CreatorID)
-		assert.Len(t, responseGame.Players, 1)
-		assert.Equal(t, player1.ID, responseGame.Players[0].ID)
+	t.Run("requires authentication", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, getGame(r, newGame.ID, "").Code)
 	})
 
 	t.Run("game not found", func(t *testing.T) {
-		db := tests.SetupTestDB(t)
-		r := setupRouter(db)
-
-		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/games/"+uuid.New().String(), nil)
-		r.ServeHTTP(w, req)
-
+		w := getGame(r, uuid.New(), creatorToken)
 		assert.Equal(t, http.StatusNotFound, w.Code)
 		assert.Contains(t, w.Body.String(), "game not found")
 	})
 }
-
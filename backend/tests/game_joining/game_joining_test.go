@@ -1,12 +1,14 @@
 package game_joining
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"panzerstadt/async-multiplayer/game"
 	"panzerstadt/async-multiplayer/tests"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -140,3 +142,115 @@ func TestJoinGame(t *testing.T) {
 		assert.Equal(t, 2, players[2].TurnOrder)
 	})
 }
+
+func joinGame(r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/join-game/"+gameID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestJoinGameCapacity(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	fullGame := game.Game{Name: "Full Game - " + uuid.New().String(), MaxPlayers: 1}
+	require.NoError(t, db.Create(&fullGame).Error)
+
+	first, err := tests.CreateTestUser(db, "capacity-first@example.com")
+	require.NoError(t, err)
+	firstToken, err := tests.GetTestUserToken(first.ID, first.Email, cfg)
+	require.NoError(t, err)
+	second, err := tests.CreateTestUser(db, "capacity-second@example.com")
+	require.NoError(t, err)
+	secondToken, err := tests.GetTestUserToken(second.ID, second.Email, cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, joinGame(r, fullGame.ID, firstToken).Code)
+	w := joinGame(r, fullGame.ID, secondToken)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "game is full")
+}
+
+func TestJoinGameBroadcastsPlayerJoined(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	sseManager := &tests.MockSSEManager{}
+	r := gin.New()
+	r.POST("/join-game/:id", game.AuthMiddleware(db, cfg), game.JoinGameHandler(db, cfg, sseManager))
+
+	user, err := tests.CreateTestUser(db, "broadcast-join@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(user.ID, user.Email, cfg)
+	require.NoError(t, err)
+
+	newGame := game.Game{Name: "Broadcast Game - " + uuid.New().String()}
+	require.NoError(t, db.Create(&newGame).Error)
+
+	require.Equal(t, http.StatusOK, joinGame(r, newGame.ID, token).Code)
+	assert.Equal(t, "player_joined", sseManager.LastEvent)
+}
+
+func TestClosedLobbyJoinRequest(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "lobby-creator@example.com")
+	require.NoError(t, err)
+	creatorToken, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	require.NoError(t, err)
+	latecomer, err := tests.CreateTestUser(db, "lobby-latecomer@example.com")
+	require.NoError(t, err)
+	latecomerToken, err := tests.GetTestUserToken(latecomer.ID, latecomer.Email, cfg)
+	require.NoError(t, err)
+
+	started := game.Game{Name: "Started Game - " + uuid.New().String(), CreatorID: creator.ID}
+	require.NoError(t, db.Create(&started).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: creator.ID, GameID: started.ID}).Error)
+	require.NoError(t, db.Model(&started).Update("lobby_open", false).Error)
+
+	w := joinGame(r, started.ID, latecomerToken)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var joinResponse struct {
+		RequestID string `json:"request_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &joinResponse))
+
+	assert.Equal(t, http.StatusConflict, joinGame(r, started.ID, latecomerToken).Code, "a second request should be refused")
+
+	// The owner sees who asked, but not their email.
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/games/"+started.ID.String()+"/join-requests", nil)
+	req.Header.Set("Authorization", "Bearer "+creatorToken)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var pending []game.JoinRequestResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	require.Len(t, pending, 1)
+	assert.Equal(t, latecomer.ID, pending[0].User.ID)
+	assert.NotContains(t, w.Body.String(), "email")
+	assert.NotContains(t, w.Body.String(), latecomer.Email)
+
+	// Only the creator can approve.
+	path := "/api/games/" + started.ID.String() + "/join-requests/" + joinResponse.RequestID + "/approve"
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", path, nil)
+	req.Header.Set("Authorization", "Bearer "+latecomerToken)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", path, nil)
+	req.Header.Set("Authorization", "Bearer "+creatorToken)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var seat game.Player
+	require.NoError(t, db.Where("game_id = ? AND user_id = ?", started.ID, latecomer.ID).First(&seat).Error)
+	assert.Equal(t, 1, seat.TurnOrder)
+}
@@ -0,0 +1,115 @@
+package game_settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func patchGame(r *gin.Engine, gameID uuid.UUID, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/api/games/"+gameID.String(), bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateGameSettings(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "settings-creator@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Settings Game - " + uuid.New().String(), CreatorID: creator.ID}
+	require.NoError(t, db.Create(&g).Error)
+
+	w := patchGame(r, g.ID, token, `{
+		"settings": {
+			"game_type": "civ6",
+			"map_type": "pangaea",
+			"description": "Friday campaign",
+			"mods": ["Better Balanced Game"],
+			"turn_deadline_hours": 48,
+			"options": {"game_speed": "online", "barbarians": false}
+		}
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response game.GameResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "civ6", response.Settings.GameType)
+	assert.NotContains(t, w.Body.String(), "deleted_at", "the response is the game DTO")
+
+	var updated game.Game
+	require.NoError(t, db.First(&updated, "id = ?", g.ID).Error)
+	assert.Equal(t, "civ6", updated.Settings.GameType)
+	assert.Equal(t, "pangaea", updated.Settings.MapType)
+	assert.Equal(t, []string{"Better Balanced Game"}, updated.Settings.Mods)
+	assert.Equal(t, 48, updated.Settings.TurnDeadlineHours)
+	assert.Equal(t, "online", updated.Settings.Options["game_speed"])
+
+	t.Run("partial update keeps other settings", func(t *testing.T) {
+		w := patchGame(r, g.ID, token, `{"settings": {"house_rules": "No early rushes"}}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var reloaded game.Game
+		require.NoError(t, db.First(&reloaded, "id = ?", g.ID).Error)
+		assert.Equal(t, "No early rushes", reloaded.Settings.HouseRules)
+		assert.Equal(t, "pangaea", reloaded.Settings.MapType)
+	})
+
+	t.Run("leaves columns it does not change alone", func(t *testing.T) {
+		// An upload moves the turn on between loading the game and saving it.
+		turn := uuid.New()
+		callback := "test:advance_turn"
+		require.NoError(t, db.Callback().Update().Before("gorm:update").Register(callback, func(tx *gorm.DB) {
+			tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec("UPDATE games SET current_turn_id = ?, round = 7 WHERE id = ?", turn, g.ID)
+		}))
+		w := patchGame(r, g.ID, token, `{"name": "Renamed Game - `+uuid.New().String()+`"}`)
+		require.NoError(t, db.Callback().Update().Remove(callback))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var reloaded game.Game
+		require.NoError(t, db.First(&reloaded, "id = ?", g.ID).Error)
+		require.NotNil(t, reloaded.CurrentTurnID)
+		assert.Equal(t, turn, *reloaded.CurrentTurnID)
+		assert.Equal(t, 7, reloaded.Round)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		cases := []string{
+			`{"settings": {"game_type": "monopoly"}}`,
+			`{"settings": {"map_type": "donut"}}`,
+			`{"settings": {"options": {"game_speed": "ludicrous"}}}`,
+			`{"settings": {"options": {"unknown": 1}}}`,
+			`{"settings": {"turn_deadline_hours": -1}}`,
+			`{"max_players": -2}`,
+		}
+		for _, body := range cases {
+			assert.Equal(t, http.StatusBadRequest, patchGame(r, g.ID, token, body).Code, body)
+		}
+	})
+
+	t.Run("only the creator can edit", func(t *testing.T) {
+		other, err := tests.CreateTestUser(db, "settings-other@example.com")
+		require.NoError(t, err)
+		otherToken, err := tests.GetTestUserToken(other.ID, other.Email, cfg)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, patchGame(r, g.ID, otherToken, `{"name": "Hijacked"}`).Code)
+	})
+}
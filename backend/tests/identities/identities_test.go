@@ -0,0 +1,296 @@
+package identities
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeProvider returns a fixed identity without talking to anyone.
+type fakeProvider struct {
+	name     string
+	identity game.ProviderIdentity
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) LoginURL(state string) string {
+	return "https://id.example.com/login?state=" + state
+}
+
+func (p *fakeProvider) Identify(ctx context.Context, query url.Values) (game.ProviderIdentity, error) {
+	identity := p.identity
+	identity.Provider = p.name
+	return identity, nil
+}
+
+func callback(r *gin.Engine, provider, state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/"+provider+"/callback?state="+url.QueryEscape(state)+"&code=x", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func loginCallback(r *gin.Engine, provider string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	return callback(r, provider, "test-state", append(cookies, &http.Cookie{Name: "oauthstate", Value: "test-state"})...)
+}
+
+// startLink begins linking provider to the token's user and returns the OAuth
+// state and the cookies the browser would have been given.
+func startLink(t *testing.T, r *gin.Engine, token, provider string) (string, []*http.Cookie) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/user/link/"+provider, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var linkResponse struct {
+		URL string `json:"url"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &linkResponse))
+	loginURL, err := url.Parse(linkResponse.URL)
+	require.NoError(t, err)
+	return loginURL.Query().Get("state"), w.Result().Cookies()
+}
+
+func TestLoginCreatesIdentity(t *testing.T) {
+	db, r, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	game.RegisterProvider(&fakeProvider{name: "fakehub", identity: game.ProviderIdentity{
+		Subject: "fh-1", Email: " Linker@Example.com", EmailVerified: true,
+	}})
+	game.RegisterProvider(&fakeProvider{name: "fakecord", identity: game.ProviderIdentity{
+		Subject: "fc-1", Email: "linker@example.com", EmailVerified: true,
+	}})
+
+	require.Equal(t, http.StatusTemporaryRedirect, loginCallback(r, "fakehub").Code)
+	// A second provider with the same verified email reaches the same user,
+	// whatever its case.
+	require.Equal(t, http.StatusTemporaryRedirect, loginCallback(r, "fakecord").Code)
+
+	var users []game.User
+	require.NoError(t, db.Preload("Identities").Where("email = ?", "linker@example.com").Find(&users).Error)
+	require.Len(t, users, 1)
+	assert.Len(t, users[0].Identities, 2)
+}
+
+func TestLinkIdentity(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	game.RegisterProvider(&fakeProvider{name: "fakesteam", identity: game.ProviderIdentity{Subject: "7656119"}})
+	mainUser, err := tests.CreateTestUser(db, "main@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(mainUser.ID, mainUser.Email, cfg)
+	require.NoError(t, err)
+
+	state, cookies := startLink(t, r, token, "fakesteam")
+	require.NotEmpty(t, state)
+	require.Equal(t, http.StatusTemporaryRedirect, callback(r, "fakesteam", state, cookies...).Code)
+
+	var identity game.UserIdentity
+	require.NoError(t, db.Where("provider = ? AND subject = ?", "fakesteam", "7656119").First(&identity).Error)
+	assert.Equal(t, mainUser.ID, identity.UserID)
+}
+
+func TestLinkNeverTakesOverAnotherAccount(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	// The victim signed in with a provider and plays a game.
+	game.RegisterProvider(&fakeProvider{name: "fakeguard", identity: game.ProviderIdentity{Subject: "victim-1"}})
+	require.Equal(t, http.StatusTemporaryRedirect, loginCallback(r, "fakeguard").Code)
+	var victimIdentity game.UserIdentity
+	require.NoError(t, db.Where("provider = ? AND subject = ?", "fakeguard", "victim-1").First(&victimIdentity).Error)
+	victimSeat := game.Player{UserID: victimIdentity.UserID, GameID: uuid.New()}
+	require.NoError(t, db.Create(&victimSeat).Error)
+
+	attacker, err := tests.CreateTestUser(db, "attacker@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(attacker.ID, attacker.Email, cfg)
+	require.NoError(t, err)
+	state, cookies := startLink(t, r, token, "fakeguard")
+
+	// A link token in a login URL is ignored.
+	var linkToken string
+	for _, cookie := range cookies {
+		if cookie.Name == "oauthlink" {
+			linkToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, linkToken)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/fakeguard/login?link="+url.QueryEscape(linkToken), nil)
+	r.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		assert.NotEqual(t, "oauthlink", cookie.Name)
+	}
+
+	// A link cookie only counts together with the state it was issued with.
+	linkCookie := &http.Cookie{Name: "oauthlink", Value: linkToken}
+	require.Equal(t, http.StatusTemporaryRedirect, loginCallback(r, "fakeguard", linkCookie).Code)
+
+	// Even with both, an identity of another account is not moved; the
+	// linking user is only offered a merge.
+	w = callback(r, "fakeguard", state, cookies...)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	redirect, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, redirect.Query().Get("merge"))
+
+	require.NoError(t, db.First(&victimIdentity, "id = ?", victimIdentity.ID).Error)
+	assert.NotEqual(t, attacker.ID, victimIdentity.UserID)
+	require.NoError(t, db.First(&victimSeat, "id = ?", victimSeat.ID).Error)
+	assert.Equal(t, victimIdentity.UserID, victimSeat.UserID)
+	assert.NoError(t, db.First(&game.User{}, "id = ?", victimIdentity.UserID).Error)
+}
+
+func TestMergeDeduplicatesSeats(t *testing.T) {
+	db, _, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	target, err := tests.CreateTestUser(db, "target@example.com")
+	require.NoError(t, err)
+	source, err := tests.CreateTestUser(db, "source@example.com")
+	require.NoError(t, err)
+	other, err := tests.CreateTestUser(db, "other@example.com")
+	require.NoError(t, err)
+
+	shared := game.Game{Name: "Shared Game", CreatorID: other.ID}
+	require.NoError(t, db.Create(&shared).Error)
+	awayUntil := time.Now().Add(24 * time.Hour)
+	targetSeat := game.Player{UserID: target.ID, GameID: shared.ID, TurnOrder: 0, TimeUsedSeconds: 60}
+	sourceSeat := game.Player{UserID: source.ID, GameID: shared.ID, TurnOrder: 1, Role: game.RoleAdmin, TimeUsedSeconds: 40, AwayUntil: &awayUntil}
+	otherSeat := game.Player{UserID: other.ID, GameID: shared.ID, TurnOrder: 2, Role: game.RoleOwner}
+	for _, p := range []*game.Player{&targetSeat, &sourceSeat, &otherSeat} {
+		require.NoError(t, db.Create(p).Error)
+	}
+	require.NoError(t, db.Model(&shared).Update("current_turn_id", sourceSeat.ID).Error)
+	save := game.Save{GameID: shared.ID, UploadedBy: source.ID, PlayerID: &sourceSeat.ID, FilePath: "unused"}
+	require.NoError(t, db.Create(&save).Error)
+
+	require.NoError(t, game.MergeUsers(db, target.ID, source.ID))
+
+	var players []game.Player
+	require.NoError(t, db.Where("game_id = ?", shared.ID).Order("turn_order ASC").Find(&players).Error)
+	require.Len(t, players, 2)
+	kept := players[0]
+	assert.Equal(t, targetSeat.ID, kept.ID)
+	assert.Equal(t, game.RoleAdmin, kept.Role, "the stronger role is kept")
+	assert.Equal(t, int64(100), kept.TimeUsedSeconds)
+	require.NotNil(t, kept.AwayUntil)
+	assert.True(t, awayUntil.Equal(*kept.AwayUntil))
+	assert.Equal(t, otherSeat.ID, players[1].ID)
+	assert.Equal(t, 1, players[1].TurnOrder, "the turn order is closed up")
+
+	require.NoError(t, db.First(&save, "id = ?", save.ID).Error)
+	assert.Equal(t, targetSeat.ID, *save.PlayerID)
+	assert.Equal(t, target.ID, save.UploadedBy)
+
+	require.NoError(t, db.First(&shared, "id = ?", shared.ID).Error)
+	require.NotNil(t, shared.CurrentTurnID)
+	assert.Equal(t, targetSeat.ID, *shared.CurrentTurnID)
+}
+
+func mergeAccounts(r *gin.Engine, token, mergeToken string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/user/merge", strings.NewReader(`{"merge_token":"`+mergeToken+`"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMergeAfterLinkingAnotherAccount(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	// An old account made with a provider login sits in the same game.
+	game.RegisterProvider(&fakeProvider{name: "fakeold", identity: game.ProviderIdentity{Subject: "old-1"}})
+	require.Equal(t, http.StatusTemporaryRedirect, loginCallback(r, "fakeold").Code)
+	var oldIdentity game.UserIdentity
+	require.NoError(t, db.Where("provider = ? AND subject = ?", "fakeold", "old-1").First(&oldIdentity).Error)
+
+	current, err := tests.CreateTestUser(db, "current@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(current.ID, current.Email, cfg)
+	require.NoError(t, err)
+	shared := game.Game{Name: "Merged Game - " + uuid.New().String(), CreatorID: current.ID}
+	require.NoError(t, db.Create(&shared).Error)
+	currentSeat := game.Player{UserID: current.ID, GameID: shared.ID, TurnOrder: 0, Role: game.RoleOwner}
+	oldSeat := game.Player{UserID: oldIdentity.UserID, GameID: shared.ID, TurnOrder: 1}
+	for _, p := range []*game.Player{&currentSeat, &oldSeat} {
+		require.NoError(t, db.Create(p).Error)
+	}
+
+	// Linking the old login while signed in offers a merge.
+	state, cookies := startLink(t, r, token, "fakeold")
+	w := callback(r, "fakeold", state, cookies...)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	redirect, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	mergeToken := redirect.Query().Get("merge")
+	require.NotEmpty(t, mergeToken)
+
+	// Nobody else can use the token.
+	bystander, err := tests.CreateTestUser(db, "bystander@example.com")
+	require.NoError(t, err)
+	bystanderToken, err := tests.GetTestUserToken(bystander.ID, bystander.Email, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, mergeAccounts(r, bystanderToken, mergeToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, mergeAccounts(r, token, "not-a-token").Code)
+
+	w = mergeAccounts(r, token, mergeToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(t, db.First(&oldIdentity, "id = ?", oldIdentity.ID).Error)
+	assert.Equal(t, current.ID, oldIdentity.UserID)
+	assert.ErrorIs(t, db.First(&game.User{}, "id = ?", oldSeat.UserID).Error, gorm.ErrRecordNotFound)
+	var seats []game.Player
+	require.NoError(t, db.Where("game_id = ?", shared.ID).Find(&seats).Error)
+	require.Len(t, seats, 1, "the two seats are combined")
+	assert.Equal(t, currentSeat.ID, seats[0].ID)
+
+	assert.Equal(t, http.StatusNotFound, mergeAccounts(r, token, mergeToken).Code, "the token cannot be replayed")
+}
+
+func TestUnlinkLastIdentity(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	user, err := tests.CreateTestUser(db, "lonely@example.com")
+	require.NoError(t, err)
+	identity := game.UserIdentity{UserID: user.ID, Provider: "google", Subject: "g-1"}
+	require.NoError(t, db.Create(&identity).Error)
+	token, err := tests.GetTestUserToken(user.ID, user.Email, cfg)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/user/identities/"+identity.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,150 @@
+package invitations
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var invitePattern = regexp.MustCompile(`https?://\S+/invites/\S+\?token=\S+`)
+
+func authedRequest(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createGame(t *testing.T, r *gin.Engine, token, body string) string {
+	w := authedRequest(r, "POST", "/create-game", token, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		GameID string `json:"game_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.GameID
+}
+
+func TestCreateGameSendsInvitations(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "host@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	require.NoError(t, err)
+
+	gameID := createGame(t, r, token, `{"name":"Invited Game","players":["friend@example.com"]}`)
+
+	// Invitees are not seated until they accept.
+	var players int64
+	db.Model(&game.Player{}).Where("game_id = ?", gameID).Count(&players)
+	assert.Equal(t, int64(1), players)
+
+	var invitation game.Invitation
+	require.NoError(t, db.Where("game_id = ?", gameID).First(&invitation).Error)
+	assert.Equal(t, game.InvitationPending, invitation.Status)
+	assert.Equal(t, "friend@example.com", mockNotifier.LastRecipientEmail)
+	assert.NotEmpty(t, invitePattern.FindString(mockNotifier.LastBody))
+
+	friend, err := tests.CreateTestUser(db, "friend@example.com")
+	require.NoError(t, err)
+	friendToken, err := tests.GetTestUserToken(friend.ID, friend.Email, cfg)
+	require.NoError(t, err)
+
+	w := authedRequest(r, "GET", "/api/user/invites", friendToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), invitation.ID.String())
+
+	w = authedRequest(r, "POST", "/api/invites/"+invitation.ID.String()+"/accept", friendToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var seat game.Player
+	require.NoError(t, db.Where("game_id = ? AND user_id = ?", gameID, friend.ID).First(&seat).Error)
+	assert.Equal(t, 1, seat.TurnOrder)
+
+	t.Run("cannot be answered twice", func(t *testing.T) {
+		w := authedRequest(r, "POST", "/api/invites/"+invitation.ID.String()+"/decline", friendToken, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestInviteLinkToken(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "link-host@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(creator.ID, creator.Email, cfg)
+	require.NoError(t, err)
+
+	gameID := createGame(t, r, token, `{"name":"Private Game","join_policy":"invite_only"}`)
+
+	// Someone signed in with a different address than the one invited.
+	other, err := tests.CreateTestUser(db, "other-address@example.com")
+	require.NoError(t, err)
+	otherToken, err := tests.GetTestUserToken(other.ID, other.Email, cfg)
+	require.NoError(t, err)
+
+	w := authedRequest(r, "POST", "/join-game/"+gameID, otherToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authedRequest(r, "POST", "/api/games/"+gameID+"/invites", otherToken, `{"emails":["x@example.com"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authedRequest(r, "POST", "/api/games/"+gameID+"/invites", token, `{"emails":["work-address@example.com"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	link, err := url.Parse(invitePattern.FindString(mockNotifier.LastBody))
+	require.NoError(t, err)
+	inviteToken := link.Query().Get("token")
+	require.NotEmpty(t, inviteToken)
+
+	w = authedRequest(r, "POST", "/join-game/"+gameID+"?invite="+url.QueryEscape(inviteToken), otherToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var invitation game.Invitation
+	require.NoError(t, db.Where("game_id = ?", gameID).First(&invitation).Error)
+	assert.Equal(t, game.InvitationAccepted, invitation.Status)
+}
+
+func TestExpiredAndForeignInvitations(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	creator, err := tests.CreateTestUser(db, "expiry-host@example.com")
+	require.NoError(t, err)
+	invitee, err := tests.CreateTestUser(db, "late@example.com")
+	require.NoError(t, err)
+	inviteeToken, err := tests.GetTestUserToken(invitee.ID, invitee.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Expiring Game", CreatorID: creator.ID}
+	require.NoError(t, db.Create(&g).Error)
+
+	expired := game.Invitation{GameID: g.ID, Email: invitee.Email, InvitedBy: creator.ID, ExpiresAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, db.Create(&expired).Error)
+	w := authedRequest(r, "POST", "/api/invites/"+expired.ID.String()+"/accept", inviteeToken, "")
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	foreign := game.Invitation{GameID: g.ID, Email: "someone-else@example.com", InvitedBy: creator.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, db.Create(&foreign).Error)
+	w = authedRequest(r, "POST", "/api/invites/"+foreign.ID.String()+"/accept", inviteeToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,124 @@
+package jwt_keys
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/config"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func claimsFor(cfg config.Config, userID uuid.UUID) game.Claims {
+	now := time.Now()
+	return game.Claims{
+		Email: "keys@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   userID.String(),
+			Issuer:    cfg.JwtIssuer,
+			Audience:  jwt.ClaimStrings{cfg.JwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func status(db *gorm.DB, cfg config.Config, token string) int {
+	r := gin.New()
+	r.GET("/whoami", game.AuthMiddleware(db, cfg), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestKeyRotation(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+	userID := uuid.New()
+
+	oldCfg := cfg
+	oldCfg.JwtKeys = "2024:old-secret"
+	oldCfg.JwtActiveKeyID = "2024"
+	oldToken, err := game.SignToken(oldCfg, claimsFor(oldCfg, userID))
+	require.NoError(t, err)
+
+	// The new key signs, while the old one is still accepted for verification.
+	rotatedCfg := cfg
+	rotatedCfg.JwtKeys = "2025:new-secret,2024:old-secret"
+	newToken, err := game.SignToken(rotatedCfg, claimsFor(rotatedCfg, userID))
+	require.NoError(t, err)
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &game.Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2025", parsed.Header["kid"])
+
+	assert.Equal(t, http.StatusOK, status(db, rotatedCfg, oldToken))
+	assert.Equal(t, http.StatusOK, status(db, rotatedCfg, newToken))
+
+	// Once the old key is retired its tokens are rejected.
+	retiredCfg := cfg
+	retiredCfg.JwtKeys = "2025:new-secret"
+	assert.Equal(t, http.StatusUnauthorized, status(db, retiredCfg, oldToken))
+	assert.Equal(t, http.StatusOK, status(db, retiredCfg, newToken))
+}
+
+func TestClaimsValidation(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+	userID := uuid.New()
+
+	t.Run("wrong audience", func(t *testing.T) {
+		claims := claimsFor(cfg, userID)
+		claims.Audience = jwt.ClaimStrings{"someone-else"}
+		token, err := game.SignToken(cfg, claims)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, status(db, cfg, token))
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		claims := claimsFor(cfg, userID)
+		claims.Issuer = "https://evil.example.com"
+		token, err := game.SignToken(cfg, claims)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, status(db, cfg, token))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		claims := claimsFor(cfg, userID)
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		token, err := game.SignToken(cfg, claims)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, status(db, cfg, token))
+	})
+
+	t.Run("typed user id in context", func(t *testing.T) {
+		token, err := game.SignToken(cfg, claimsFor(cfg, userID))
+		require.NoError(t, err)
+
+		r := gin.New()
+		var got interface{}
+		r.GET("/whoami", game.AuthMiddleware(db, cfg), func(c *gin.Context) {
+			got, _ = c.Get("userID")
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, userID, got)
+	})
+}
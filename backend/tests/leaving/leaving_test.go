@@ -0,0 +1,106 @@
+package leaving
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// seatPlayers creates a game owned by the first user with everyone seated in
+// the given order.
+func seatPlayers(t *testing.T, db *gorm.DB, users ...*game.User) (game.Game, []game.Player) {
+	g := game.Game{Name: "Leave Game - " + uuid.New().String(), CreatorID: users[0].ID}
+	require.NoError(t, db.Create(&g).Error)
+
+	players := make([]game.Player, len(users))
+	for i, u := range users {
+		players[i] = game.Player{UserID: u.ID, GameID: g.ID, TurnOrder: i}
+		require.NoError(t, db.Create(&players[i]).Error)
+	}
+	return g, players
+}
+
+func send(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLeaveGamePassesTurn(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "leave-host@example.com")
+	middle, _ := tests.CreateTestUser(db, "leave-middle@example.com")
+	require.NoError(t, db.Model(middle).Update("display_name", "Middle").Error)
+	last, _ := tests.CreateTestUser(db, "leave-last@example.com")
+	g, players := seatPlayers(t, db, host, middle, last)
+	require.NoError(t, db.Model(&g).Update("current_turn_id", players[1].ID).Error)
+
+	token, err := tests.GetTestUserToken(middle.ID, middle.Email, cfg)
+	require.NoError(t, err)
+
+	w := send(r, "POST", "/api/games/"+g.ID.String()+"/leave", token)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var remaining []game.Player
+	require.NoError(t, db.Where("game_id = ?", g.ID).Order("turn_order ASC").Find(&remaining).Error)
+	require.Len(t, remaining, 2)
+	assert.Equal(t, 0, remaining[0].TurnOrder)
+	assert.Equal(t, last.ID, remaining[1].UserID)
+	assert.Equal(t, 1, remaining[1].TurnOrder)
+
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	require.NotNil(t, g.CurrentTurnID)
+	assert.Equal(t, players[2].ID, *g.CurrentTurnID, "the turn should pass to the next seat")
+	assert.NotEmpty(t, mockNotifier.LastRecipientEmail)
+	assert.Contains(t, mockNotifier.LastBody, "Middle left")
+	assert.NotContains(t, mockNotifier.LastBody, middle.Email)
+
+	t.Run("creator cannot leave", func(t *testing.T) {
+		hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+		require.NoError(t, err)
+		w := send(r, "POST", "/api/games/"+g.ID.String()+"/leave", hostToken)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestKickLastPlayerWrapsTurn(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "kick-host@example.com")
+	idle, _ := tests.CreateTestUser(db, "kick-idle@example.com")
+	g, players := seatPlayers(t, db, host, idle)
+	require.NoError(t, db.Model(&g).Update("current_turn_id", players[1].ID).Error)
+
+	idleToken, err := tests.GetTestUserToken(idle.ID, idle.Email, cfg)
+	require.NoError(t, err)
+	w := send(r, "DELETE", "/api/games/"+g.ID.String()+"/players/"+players[0].ID.String(), idleToken)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the creator can kick")
+
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	w = send(r, "DELETE", "/api/games/"+g.ID.String()+"/players/"+players[1].ID.String(), hostToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	require.NotNil(t, g.CurrentTurnID)
+	assert.Equal(t, players[0].ID, *g.CurrentTurnID)
+
+	var count int64
+	db.Model(&game.Player{}).Where("game_id = ?", g.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
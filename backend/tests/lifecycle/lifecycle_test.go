@@ -0,0 +1,119 @@
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func uploadSave(t *testing.T, r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGameLifecycle(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	host, _ := tests.CreateTestUser(db, "lifecycle-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "lifecycle-rival@example.com")
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Lifecycle Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	rivalSeat := game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}
+	require.NoError(t, db.Create(&rivalSeat).Error)
+	assert.Equal(t, game.GameStatusLobby, g.Status)
+
+	base := "/api/games/" + g.ID.String()
+
+	assert.Equal(t, http.StatusConflict, send(r, "POST", base+"/resume", token, "").Code, "a lobby game cannot be resumed")
+	require.Equal(t, http.StatusOK, send(r, "POST", base+"/start", token, "").Code)
+	require.Equal(t, http.StatusOK, send(r, "POST", base+"/pause", token, "").Code)
+	assert.Equal(t, "lifecycle-rival@example.com", mockNotifier.LastRecipientEmail)
+
+	w := uploadSave(t, r, g.ID, token)
+	assert.Equal(t, http.StatusConflict, w.Code, "uploads are blocked while paused")
+
+	require.Equal(t, http.StatusOK, send(r, "POST", base+"/resume", token, "").Code)
+	assert.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token).Code)
+
+	w = send(r, "POST", base+"/finish", token, `{"winner_player_id":"`+rivalSeat.ID.String()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.Equal(t, game.GameStatusFinished, g.Status)
+	require.NotNil(t, g.WinnerID)
+	assert.Equal(t, rivalSeat.ID, *g.WinnerID)
+
+	require.Equal(t, http.StatusOK, send(r, "POST", base+"/archive", token, "").Code)
+
+	t.Run("archived games are hidden from the game list", func(t *testing.T) {
+		var games []game.Game
+		w := send(r, "GET", "/api/user/games", token, "")
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &games))
+		assert.Empty(t, games)
+
+		w = send(r, "GET", "/api/user/games?include_archived=true", token, "")
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &games))
+		assert.Len(t, games, 1)
+	})
+}
+
+func TestFirstUploadStartsGame(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	host, _ := tests.CreateTestUser(db, "autostart-host@example.com")
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Autostart Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID}).Error)
+
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token).Code)
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.Equal(t, game.GameStatusActive, g.Status)
+	assert.False(t, g.LobbyOpen)
+}
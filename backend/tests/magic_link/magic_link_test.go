@@ -0,0 +1,155 @@
+package magic_link
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var linkPattern = regexp.MustCompile(`https?://\S+/auth/email/callback\?token=\S+`)
+
+func requestLink(r *gin.Engine, email string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/email/login", bytes.NewBufferString(`{"email":"`+email+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func followLink(r *gin.Engine, backendUrl, link string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", strings.TrimPrefix(link, backendUrl), nil)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// confirmLink submits the confirmation form the link shows.
+func confirmLink(r *gin.Engine, link string) *httptest.ResponseRecorder {
+	linkURL, _ := url.Parse(link)
+	form := url.Values{"token": {linkURL.Query().Get("token")}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/email/callback", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func exchangeCode(r *gin.Engine, code string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/token", bytes.NewBufferString(`{"code":"`+code+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	// An existing email user.
+	invitee := game.User{Email: "invitee@example.com", AuthProvider: "email"}
+	require.NoError(t, db.Create(&invitee).Error)
+
+	w := requestLink(r, invitee.Email)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, invitee.Email, mockNotifier.LastRecipientEmail)
+
+	link := linkPattern.FindString(mockNotifier.LastBody)
+	require.NotEmpty(t, link, "email should contain a login link")
+
+	// Opening the link only asks for confirmation, so a mail scanner that
+	// fetches it first does not use it up.
+	for i := 0; i < 2; i++ {
+		w = followLink(r, cfg.BackendUrl, link)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `<form method="post" action="/auth/email/callback">`)
+	}
+
+	w = confirmLink(r, link)
+	require.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	redirectURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	code := redirectURL.Query().Get("code")
+	require.NotEmpty(t, code)
+	assert.NotContains(t, redirectURL.String(), "token", "tokens never go in the redirect URL")
+
+	// The frontend trades the code for tokens, once.
+	w = exchangeCode(r, code)
+	require.Equal(t, http.StatusOK, w.Code)
+	var tokens game.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, exchangeCode(r, code).Code)
+	assert.Equal(t, http.StatusUnauthorized, exchangeCode(r, "made-up").Code)
+
+	var identity game.UserIdentity
+	require.NoError(t, db.Where("provider = ? AND user_id = ?", "email", invitee.ID).First(&identity).Error)
+
+	t.Run("link cannot be replayed", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, confirmLink(r, link).Code)
+		assert.Equal(t, http.StatusUnauthorized, followLink(r, cfg.BackendUrl, link).Code)
+	})
+}
+
+func TestMagicLinkUnknownEmail(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, _ := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	w := requestLink(r, "stranger@example.com")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, mockNotifier.LastRecipientEmail, "no email should be sent to unknown addresses")
+}
+
+func TestMagicLinkExpired(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, _, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	user, err := tests.CreateTestUser(db, "slowpoke@example.com")
+	require.NoError(t, err)
+
+	// Issue the link with a TTL that has already passed.
+	cfg.MagicLinkTTL = -1
+	r := gin.New()
+	r.POST("/auth/email/login", game.RequestMagicLinkHandler(db, cfg, mockNotifier))
+	r.GET("/auth/email/callback", game.MagicLinkCallbackHandler(db, cfg))
+	r.POST("/auth/email/callback", game.ConfirmMagicLinkHandler(db, cfg))
+
+	require.Equal(t, http.StatusAccepted, requestLink(r, user.Email).Code)
+	link := linkPattern.FindString(mockNotifier.LastBody)
+	require.NotEmpty(t, link)
+
+	assert.Equal(t, http.StatusUnauthorized, followLink(r, cfg.BackendUrl, link).Code)
+	assert.Equal(t, http.StatusUnauthorized, confirmLink(r, link).Code)
+}
+
+func TestMagicLinkIgnoresEmailCase(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, _ := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	// Stored before emails were normalised.
+	legacy := game.User{Email: "Legacy@Example.com", AuthProvider: "email"}
+	require.NoError(t, db.Create(&legacy).Error)
+
+	require.Equal(t, http.StatusAccepted, requestLink(r, "legacy@EXAMPLE.com").Code)
+	assert.Equal(t, legacy.Email, mockNotifier.LastRecipientEmail)
+
+	var count int64
+	require.NoError(t, db.Model(&game.User{}).Where("LOWER(email) = ?", "legacy@example.com").Count(&count).Error)
+	assert.Equal(t, int64(1), count, "no second account is created")
+}
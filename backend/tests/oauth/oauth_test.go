@@ -66,7 +66,8 @@ func TestGoogleOAuth(t *testing.T) {
 		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 		redirectURL, err := w.Result().Location()
 		require.NoError(t, err)
-		assert.Contains(t, redirectURL.String(), "token=")
+		assert.NotEmpty(t, redirectURL.Query().Get("code"))
+		assert.NotContains(t, redirectURL.String(), "token", "tokens never go in the redirect URL")
 
 		// Verify that a user was created in the database
 		var user game.User
@@ -0,0 +1,185 @@
+package oauth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeIdentityServer serves a token endpoint and the given JSON documents.
+func newFakeIdentityServer(documents map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer"}`))
+			return
+		}
+		doc, ok := documents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+}
+
+func callback(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	req.AddCookie(&http.Cookie{Name: "oauthstate", Value: "test-state"})
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDiscordLogin(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	server := newFakeIdentityServer(map[string]string{
+		"/users/@me": `{"id": "80351110224678912", "username": "nelly", "email": "nelly@example.com", "verified": true, "avatar": "8342729096ea3675442027381ff50dfe"}`,
+	})
+	defer server.Close()
+
+	provider := game.NewDiscordProvider(cfg)
+	provider.Config.Endpoint.TokenURL = server.URL + "/token"
+	provider.UserInfoURL = server.URL + "/users/@me"
+	game.RegisterProvider(provider)
+
+	t.Run("login redirect", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/auth/discord/login", nil)
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Contains(t, w.Header().Get("Location"), "discord.com/oauth2/authorize")
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		w := callback(r, "/auth/discord/callback?state=test-state&code=test-code")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Contains(t, w.Header().Get("Location"), "code=")
+
+		var user game.User
+		require.NoError(t, db.Where("email = ?", "nelly@example.com").First(&user).Error)
+		assert.Equal(t, "discord", user.AuthProvider)
+	})
+}
+
+func TestGitHubLoginUsesVerifiedPrimaryEmail(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	server := newFakeIdentityServer(map[string]string{
+		"/user": `{"id": 583231, "login": "octocat", "name": "The Octocat", "email": null}`,
+		"/user/emails": `[
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true}
+		]`,
+	})
+	defer server.Close()
+
+	provider := game.NewGitHubProvider(cfg)
+	provider.Config.Endpoint.TokenURL = server.URL + "/token"
+	provider.UserInfoURL = server.URL + "/user"
+	provider.EmailsURL = server.URL + "/user/emails"
+	game.RegisterProvider(provider)
+
+	w := callback(r, "/auth/github/callback?state=test-state&code=test-code")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	var user game.User
+	require.NoError(t, db.Where("email = ?", "octocat@example.com").First(&user).Error)
+	assert.Equal(t, "github", user.AuthProvider)
+}
+
+func TestSteamLogin(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	var verified url.Values
+	openID := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified, _ = url.ParseQuery(string(body))
+		if verified.Get("openid.sig") == "forged" {
+			w.Write([]byte("ns:http://specs.openid.net/auth/2.0\nis_valid:false\n"))
+			return
+		}
+		w.Write([]byte("ns:http://specs.openid.net/auth/2.0\nis_valid:true\n"))
+	}))
+	defer openID.Close()
+
+	cfg.SteamReturnUrl = "http://localhost:8080/auth/steam/callback"
+	provider := game.NewSteamProvider(cfg)
+	provider.OpenIDEndpoint = openID.URL
+	game.RegisterProvider(provider)
+
+	steamCallback := func(steamID, sig string) *httptest.ResponseRecorder {
+		params := url.Values{}
+		params.Set("state", "test-state")
+		params.Set("openid.ns", "http://specs.openid.net/auth/2.0")
+		params.Set("openid.mode", "id_res")
+		params.Set("openid.return_to", cfg.SteamReturnUrl+"?state=test-state")
+		params.Set("openid.claimed_id", "https://steamcommunity.com/openid/id/"+steamID)
+		params.Set("openid.identity", "https://steamcommunity.com/openid/id/"+steamID)
+		params.Set("openid.sig", sig)
+		return callback(r, "/auth/steam/callback?"+params.Encode())
+	}
+
+	t.Run("login redirect", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/auth/steam/login", nil)
+		r.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "checkid_setup", location.Query().Get("openid.mode"))
+		assert.True(t, strings.HasPrefix(location.Query().Get("openid.return_to"), cfg.SteamReturnUrl+"?state="))
+	})
+
+	t.Run("callback creates users without email", func(t *testing.T) {
+		w := steamCallback("76561197960287930", "valid")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "check_authentication", verified.Get("openid.mode"))
+
+		// A second Steam user must not collide on the empty email.
+		w = steamCallback("76561197960287931", "valid")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+		// Logging in again reuses the existing user.
+		w = steamCallback("76561197960287930", "valid")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+		var count int64
+		db.Model(&game.User{}).Where("auth_provider = ?", "steam").Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("forged assertion", func(t *testing.T) {
+		w := steamCallback("76561197960287932", "forged")
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
+
+func TestUnknownProvider(t *testing.T) {
+	db, r, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/myspace/login", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,132 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	identity game.ProviderIdentity
+}
+
+func (p *fakeProvider) Name() string { return "profilehub" }
+
+func (p *fakeProvider) LoginURL(state string) string {
+	return "https://id.example.com/login?state=" + state
+}
+
+func (p *fakeProvider) Identify(ctx context.Context, query url.Values) (game.ProviderIdentity, error) {
+	identity := p.identity
+	identity.Provider = p.Name()
+	return identity, nil
+}
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestProviderFillsProfile(t *testing.T) {
+	db, r, _, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	game.RegisterProvider(&fakeProvider{identity: game.ProviderIdentity{
+		Subject: "ph-1", Email: "profiled@example.com", EmailVerified: true,
+		Name: "Hannibal", AvatarURL: "https://img.example.com/h.png", Locale: "sv-SE",
+	}})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/profilehub/callback?state=s&code=x", nil)
+	req.AddCookie(&http.Cookie{Name: "oauthstate", Value: "s"})
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code, w.Body.String())
+
+	var user game.User
+	require.NoError(t, db.Where("email = ?", "profiled@example.com").First(&user).Error)
+	assert.Equal(t, "Hannibal", user.DisplayName)
+	assert.Equal(t, "https://img.example.com/h.png", user.AvatarURL)
+	assert.Equal(t, "sv-SE", user.Locale)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("avatars")
+
+	user, err := tests.CreateTestUser(db, "editor@example.com")
+	require.NoError(t, err)
+	token, err := tests.GetTestUserToken(user.ID, user.Email, cfg)
+	require.NoError(t, err)
+
+	w := send(r, "PATCH", "/api/user/me", token, `{"display_name":" Scipio ","timezone":"Europe/Rome","locale":"it-IT"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send(r, "GET", "/api/user/me", token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var profile game.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	assert.Equal(t, "Scipio", profile.DisplayName)
+	assert.Equal(t, "Europe/Rome", profile.Timezone)
+	assert.Equal(t, "it-IT", profile.Locale)
+
+	for _, body := range []string{
+		`{"timezone":"Mars/Olympus"}`,
+		`{"locale":"not a locale"}`,
+		`{"avatar_url":"javascript:alert(1)"}`,
+		`{"display_name":"` + strings.Repeat("x", 51) + `"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, send(r, "PATCH", "/api/user/me", token, body).Code, body)
+	}
+
+	t.Run("avatar upload", func(t *testing.T) {
+		var img bytes.Buffer
+		require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "me.png")
+		part.Write(img.Bytes())
+		writer.Close()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/user/me/avatar", body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var updated game.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.Contains(t, updated.AvatarURL, "/users/"+user.ID.String()+"/avatar")
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/users/"+user.ID.String()+"/avatar", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, img.Bytes(), w.Body.Bytes())
+	})
+}
@@ -0,0 +1,141 @@
+package rate_limiting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/config"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitPerUser(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	limiter := game.NewRateLimiter(2, time.Minute, time.Minute)
+	r := gin.New()
+	r.POST("/limited", game.AuthMiddleware(db, cfg), limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	spammer, err := tests.CreateTestUser(db, "spammer@example.com")
+	require.NoError(t, err)
+	spammerToken, err := tests.GetTestUserToken(spammer.ID, spammer.Email, cfg)
+	require.NoError(t, err)
+
+	other, err := tests.CreateTestUser(db, "patient@example.com")
+	require.NoError(t, err)
+	otherToken, err := tests.GetTestUserToken(other.ID, other.Email, cfg)
+	require.NoError(t, err)
+
+	send := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/limited", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(spammerToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, send(spammerToken).Code)
+
+	w = send(spammerToken)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// Another user still has their own allowance.
+	assert.Equal(t, http.StatusOK, send(otherToken).Code)
+}
+
+func TestRateLimitFallsBackToClientIP(t *testing.T) {
+	limiter := game.NewRateLimiter(1, time.Minute, time.Minute)
+	r := gin.New()
+	r.GET("/limited", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(remoteAddr string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = remoteAddr
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, send("10.0.0.2:1234"))
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	limiter := game.NewRateLimiter(1, time.Minute, time.Minute)
+	r := gin.New()
+	require.NoError(t, r.SetTrustedProxies(config.Config{}.TrustedProxyList()))
+	r.GET("/limited", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// A new header on every request does not buy a new bucket.
+	assert.Equal(t, http.StatusOK, send("203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.2"))
+	assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.3"))
+
+	t.Run("trusted proxies forward the client IP", func(t *testing.T) {
+		limiter := game.NewRateLimiter(1, time.Minute, time.Minute)
+		r := gin.New()
+		require.NoError(t, r.SetTrustedProxies(config.Config{TrustedProxies: "10.0.0.0/8, 127.0.0.1"}.TrustedProxyList()))
+		r.GET("/limited", limiter.Middleware(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		for _, client := range []string{"203.0.113.1", "203.0.113.2"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/limited", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", client)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, client)
+		}
+	})
+}
+
+func TestRateLimiterRefillAndIdleExpiry(t *testing.T) {
+	now := time.Now()
+	limiter := game.NewRateLimiter(2, time.Minute, 5*time.Minute)
+	limiter.SetClock(func() time.Time { return now })
+
+	assert.True(t, limiter.Allow("a").Allowed)
+	assert.True(t, limiter.Allow("a").Allowed)
+	assert.False(t, limiter.Allow("a").Allowed)
+
+	// Half the window refills one token.
+	now = now.Add(30 * time.Second)
+	assert.True(t, limiter.Allow("a").Allowed)
+	assert.True(t, limiter.Allow("b").Allowed)
+	assert.Equal(t, 2, limiter.Len())
+
+	// Idle buckets are dropped on the next request after the expiry.
+	now = now.Add(6 * time.Minute)
+	assert.True(t, limiter.Allow("c").Allowed)
+	assert.Equal(t, 1, limiter.Len())
+}
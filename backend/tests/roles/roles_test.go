@@ -0,0 +1,115 @@
+package roles
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+type member struct {
+	user   *game.User
+	player game.Player
+	token  string
+}
+
+func addMember(t *testing.T, db *gorm.DB, g game.Game, email, role string, turnOrder int, token func(*game.User) string) member {
+	user, err := tests.CreateTestUser(db, email)
+	require.NoError(t, err)
+	player := game.Player{UserID: user.ID, GameID: g.ID, TurnOrder: turnOrder, Role: role}
+	require.NoError(t, db.Create(&player).Error)
+	return member{user: user, player: player, token: token(user)}
+}
+
+func TestAdminPermissions(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	tokenFor := func(u *game.User) string {
+		token, err := tests.GetTestUserToken(u.ID, u.Email, cfg)
+		require.NoError(t, err)
+		return token
+	}
+
+	ownerUser, err := tests.CreateTestUser(db, "roles-owner@example.com")
+	require.NoError(t, err)
+	g := game.Game{Name: "Roles Game - " + uuid.New().String(), CreatorID: ownerUser.ID}
+	require.NoError(t, db.Create(&g).Error)
+	ownerSeat := game.Player{UserID: ownerUser.ID, GameID: g.ID, Role: game.RoleOwner}
+	require.NoError(t, db.Create(&ownerSeat).Error)
+	ownerToken := tokenFor(ownerUser)
+
+	deputy := addMember(t, db, g, "roles-deputy@example.com", game.RolePlayer, 1, tokenFor)
+	regular := addMember(t, db, g, "roles-regular@example.com", game.RolePlayer, 2, tokenFor)
+	base := "/api/games/" + g.ID.String()
+
+	// Players cannot administer the game.
+	assert.Equal(t, http.StatusForbidden, send(r, "POST", base+"/pause", deputy.token, "").Code)
+
+	w := send(r, "PUT", base+"/players/"+deputy.player.ID.String()+"/role", ownerToken, `{"role":"admin"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Admins can manage the game but not own it.
+	assert.Equal(t, http.StatusOK, send(r, "POST", base+"/start", deputy.token, "").Code)
+	assert.Equal(t, http.StatusForbidden, send(r, "DELETE", base, deputy.token, "").Code)
+	assert.Equal(t, http.StatusForbidden,
+		send(r, "PUT", base+"/players/"+regular.player.ID.String()+"/role", deputy.token, `{"role":"admin"}`).Code)
+	assert.Equal(t, http.StatusForbidden,
+		send(r, "DELETE", base+"/players/"+ownerSeat.ID.String(), deputy.token, "").Code, "admins cannot kick the owner")
+
+	w = send(r, "DELETE", base+"/players/"+regular.player.ID.String(), deputy.token, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestTransferOwnership(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	tokenFor := func(u *game.User) string {
+		token, err := tests.GetTestUserToken(u.ID, u.Email, cfg)
+		require.NoError(t, err)
+		return token
+	}
+
+	ownerUser, err := tests.CreateTestUser(db, "transfer-owner@example.com")
+	require.NoError(t, err)
+	g := game.Game{Name: "Transfer Game - " + uuid.New().String(), CreatorID: ownerUser.ID}
+	require.NoError(t, db.Create(&g).Error)
+	ownerSeat := game.Player{UserID: ownerUser.ID, GameID: g.ID, Role: game.RoleOwner}
+	require.NoError(t, db.Create(&ownerSeat).Error)
+	heir := addMember(t, db, g, "transfer-heir@example.com", game.RolePlayer, 1, tokenFor)
+
+	base := "/api/games/" + g.ID.String()
+	w := send(r, "POST", base+"/transfer-ownership", tokenFor(ownerUser), `{"player_id":"`+heir.player.ID.String()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.Equal(t, heir.user.ID, g.CreatorID)
+	require.NoError(t, db.First(&ownerSeat, "id = ?", ownerSeat.ID).Error)
+	assert.Equal(t, game.RoleAdmin, ownerSeat.Role)
+
+	// The former owner can now leave; the new owner can delete.
+	assert.Equal(t, http.StatusOK, send(r, "POST", base+"/leave", tokenFor(ownerUser), "").Code)
+	assert.Equal(t, http.StatusOK, send(r, "DELETE", base, heir.token, "").Code)
+}
@@ -0,0 +1,142 @@
+package rounds
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func uploadSave(t *testing.T, r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSimultaneousRounds(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	token := func(u *game.User) string {
+		tok, err := tests.GetTestUserToken(u.ID, u.Email, cfg)
+		require.NoError(t, err)
+		return tok
+	}
+	host, _ := tests.CreateTestUser(db, "rounds-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "rounds-rival@example.com")
+	third, _ := tests.CreateTestUser(db, "rounds-third@example.com")
+
+	g := game.Game{
+		Name:      "Rounds Game - " + uuid.New().String(),
+		CreatorID: host.ID,
+		Settings:  game.GameSettings{TurnMode: game.TurnModeSimultaneous},
+	}
+	require.NoError(t, db.Create(&g).Error)
+	hostSeat := game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}
+	rivalSeat := game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}
+	thirdSeat := game.Player{UserID: third.ID, GameID: g.ID, TurnOrder: 2}
+	for _, p := range []*game.Player{&hostSeat, &rivalSeat, &thirdSeat} {
+		require.NoError(t, db.Create(p).Error)
+	}
+	details := func() game.GameResponse {
+		w := send(r, "GET", "/games/"+g.ID.String(), token(host), "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response game.GameResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// The first save starts the game without handing out a turn.
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(host)).Code)
+	state := details()
+	assert.Equal(t, game.GameStatusActive, state.Status)
+	assert.Nil(t, state.CurrentTurnID)
+	assert.Equal(t, 1, state.Round)
+	assert.ElementsMatch(t, []uuid.UUID{rivalSeat.ID, thirdSeat.ID}, state.PendingPlayerIDs)
+
+	// One save per seat each round.
+	assert.Equal(t, http.StatusConflict, uploadSave(t, r, g.ID, token(host)).Code)
+
+	// The turn mode is fixed once the game has started.
+	w := send(r, "PATCH", "/api/games/"+g.ID.String(), token(host), `{"settings": {"turn_mode": "rotation"}}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(rival)).Code)
+	assert.Equal(t, []uuid.UUID{thirdSeat.ID}, details().PendingPlayerIDs)
+
+	// The last save closes the round and everyone is told.
+	*mockNotifier = tests.MockNotifier{}
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(third)).Code)
+	state = details()
+	assert.Equal(t, 2, state.Round)
+	assert.Len(t, state.PendingPlayerIDs, 3)
+	assert.Contains(t, mockNotifier.LastSubject, "Round 2")
+
+	var saves []game.Save
+	require.NoError(t, db.Where("game_id = ?", g.ID).Find(&saves).Error)
+	require.Len(t, saves, 3)
+	for _, s := range saves {
+		assert.Equal(t, 1, s.Round)
+	}
+
+	// A pending player leaving can close the round too.
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(host)).Code)
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, token(rival)).Code)
+	require.Equal(t, http.StatusOK, send(r, "POST", "/api/games/"+g.ID.String()+"/leave", token(third), "").Code)
+	assert.Equal(t, 3, details().Round)
+}
+
+func TestSimultaneousModeRejectsChessClock(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "rounds-clock@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	g := game.Game{Name: "Rounds Clock - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, Role: game.RoleOwner}).Error)
+
+	w := send(r, "PATCH", "/api/games/"+g.ID.String(), hostToken, `{"settings": {"turn_mode": "simultaneous", "time_bank_hours": 10}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send(r, "PATCH", "/api/games/"+g.ID.String(), hostToken, `{"settings": {"turn_mode": "sideways"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send(r, "PATCH", "/api/games/"+g.ID.String(), hostToken, `{"settings": {"turn_mode": "simultaneous"}}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
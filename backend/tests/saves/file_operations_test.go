@@ -47,6 +47,10 @@ func TestUploadSave(t *testing.T) {
 		r.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
+
+		// The first save closes the lobby.
+		require.NoError(t, db.First(newGame, "id = ?", newGame.ID).Error)
+		assert.False(t, newGame.LobbyOpen)
 	})
 
 	t.Run("game not found - 404", func(t *testing.T) {
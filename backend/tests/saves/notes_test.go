@@ -0,0 +1,76 @@
+package saves_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uploadWithNote(t *testing.T, r *gin.Engine, gameID uuid.UUID, token, note string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.WriteField("note", note)
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSaveNotes(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	host, _ := tests.CreateTestUser(db, "notes-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "notes-rival@example.com")
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Notes Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}).Error)
+
+	note := "Declared war on Gandhi, watch your borders"
+	w := uploadWithNote(t, r, g.ID, token, "  "+note+"\n")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, rival.Email, mockNotifier.LastRecipientEmail)
+	assert.Contains(t, mockNotifier.LastBody, note)
+
+	assert.Equal(t, http.StatusBadRequest, uploadWithNote(t, r, g.ID, token, strings.Repeat("x", 1001)).Code)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/games/"+g.ID.String()+"/saves", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var history []game.SaveResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 1)
+	assert.Equal(t, note, history[0].Note)
+	assert.Equal(t, "Player "+host.ID.String()[:8], history[0].UploadedBy.DisplayName)
+	assert.NotContains(t, w.Body.String(), "file_path")
+}
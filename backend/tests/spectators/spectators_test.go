@@ -0,0 +1,129 @@
+package spectators
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/helpers"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func uploadSave(t *testing.T, r *gin.Engine, gameID uuid.UUID, token string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	zipContent, err := helpers.CreateDummyZip()
+	require.NoError(t, err)
+	part, _ := writer.CreateFormFile("file", "turn.zip")
+	part.Write(zipContent.Bytes())
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/games/"+gameID.String()+"/saves", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSpectatorsStayOutOfTheRotation(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+	defer os.RemoveAll("saves")
+
+	host, _ := tests.CreateTestUser(db, "spectate-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "spectate-rival@example.com")
+	watcher, _ := tests.CreateTestUser(db, "spectate-watcher@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	rivalToken, err := tests.GetTestUserToken(rival.ID, rival.Email, cfg)
+	require.NoError(t, err)
+	watcherToken, err := tests.GetTestUserToken(watcher.ID, watcher.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Spectator Game - " + uuid.New().String(), CreatorID: host.ID, MaxPlayers: 2}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}).Error)
+	rivalSeat := game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}
+	require.NoError(t, db.Create(&rivalSeat).Error)
+
+	// The game is full, but spectators do not take a seat.
+	w := send(r, "POST", "/api/games/"+g.ID.String()+"/spectate", watcherToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusConflict, send(r, "POST", "/api/games/"+g.ID.String()+"/spectate", watcherToken, "").Code)
+
+	var spectator game.Player
+	require.NoError(t, db.Where("game_id = ? AND user_id = ?", g.ID, watcher.ID).First(&spectator).Error)
+	assert.Equal(t, game.RoleSpectator, spectator.Role)
+
+	w = uploadSave(t, r, g.ID, watcherToken)
+	assert.Equal(t, http.StatusForbidden, w.Code, "spectators cannot upload")
+
+	// Turns alternate between the two players and only the next player is emailed.
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, hostToken).Code)
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	require.NotNil(t, g.CurrentTurnID)
+	assert.Equal(t, rivalSeat.ID, *g.CurrentTurnID)
+	assert.Equal(t, rival.Email, mockNotifier.LastRecipientEmail)
+
+	require.Equal(t, http.StatusCreated, uploadSave(t, r, g.ID, rivalToken).Code)
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.NotEqual(t, spectator.ID, *g.CurrentTurnID)
+	assert.Equal(t, host.Email, mockNotifier.LastRecipientEmail)
+
+	// Spectators can still download the latest save.
+	w = send(r, "GET", "/games/"+g.ID.String()+"/saves/latest", watcherToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSpectatingNeedsInvitationUnlessOpen(t *testing.T) {
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, tests.NewMockNotifier())
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "spectate-private-host@example.com")
+	watcher, _ := tests.CreateTestUser(db, "spectate-private-watcher@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	watcherToken, err := tests.GetTestUserToken(watcher.ID, watcher.Email, cfg)
+	require.NoError(t, err)
+
+	g := game.Game{Name: "Private Spectator Game - " + uuid.New().String(), CreatorID: host.ID, JoinPolicy: game.JoinPolicyInviteOnly}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, Role: game.RoleOwner}).Error)
+
+	base := "/api/games/" + g.ID.String()
+	assert.Equal(t, http.StatusForbidden, send(r, "POST", base+"/spectate", watcherToken, "").Code)
+
+	w := send(r, "POST", base+"/invites", hostToken, `{"emails":["`+watcher.Email+`"],"role":"spectator"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = send(r, "POST", base+"/spectate", watcherToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var spectator game.Player
+	require.NoError(t, db.Where("game_id = ? AND user_id = ?", g.ID, watcher.ID).First(&spectator).Error)
+	assert.Equal(t, game.RoleSpectator, spectator.Role)
+
+	assert.Equal(t, http.StatusBadRequest,
+		send(r, "POST", base+"/invites", hostToken, `{"emails":["x@example.com"],"role":"admin"}`).Code)
+}
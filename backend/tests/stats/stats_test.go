@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, r http.Handler, path, token string, out interface{}) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	if w.Code == http.StatusOK && out != nil {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+	}
+	return w.Code
+}
+
+func TestStats(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "stats-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "stats-rival@example.com")
+	leaver, _ := tests.CreateTestUser(db, "stats-leaver@example.com")
+	outsider, _ := tests.CreateTestUser(db, "stats-outsider@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	outsiderToken, err := tests.GetTestUserToken(outsider.ID, outsider.Email, cfg)
+	require.NoError(t, err)
+
+	start := time.Now().Add(-10 * 24 * time.Hour)
+	g := game.Game{
+		Name:      "Stats Game - " + uuid.New().String(),
+		CreatorID: host.ID,
+		Status:    game.GameStatusActive,
+		Settings:  game.GameSettings{TurnDeadlineHours: 24},
+		CreatedAt: start,
+	}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}).Error)
+
+	// host, rival (1h), host (30h, late), rival (3h), host (2h), then a
+	// player who has since left (4h).
+	at := start.Add(time.Hour)
+	for _, turn := range []struct {
+		user  uuid.UUID
+		after time.Duration
+	}{
+		{host.ID, 0},
+		{rival.ID, time.Hour},
+		{host.ID, 30 * time.Hour},
+		{rival.ID, 3 * time.Hour},
+		{host.ID, 2 * time.Hour},
+		{leaver.ID, 4 * time.Hour},
+	} {
+		at = at.Add(turn.after)
+		require.NoError(t, db.Create(&game.Save{GameID: g.ID, UploadedBy: turn.user, FilePath: "unused", CreatedAt: at}).Error)
+	}
+
+	var stats game.GameStats
+	require.Equal(t, http.StatusOK, get(t, r, "/api/games/"+g.ID.String()+"/stats", hostToken, &stats))
+	assert.Equal(t, 6, stats.TurnsTaken)
+	assert.InDelta(t, 6/(10.0/7), stats.TurnsPerWeek, 0.01)
+	assert.InDelta(t, (10 * 24 * time.Hour).Seconds(), stats.TotalElapsedSeconds, 5)
+
+	require.Len(t, stats.Players, 3)
+	hostStats := stats.Players[0]
+	assert.Equal(t, host.ID, hostStats.User.ID)
+	assert.Equal(t, 3, hostStats.TurnsTaken)
+	assert.Equal(t, int64((16 * time.Hour).Seconds()), hostStats.AverageTurnSeconds)
+	assert.Equal(t, int64((16 * time.Hour).Seconds()), hostStats.MedianTurnSeconds)
+	assert.Equal(t, int64((30 * time.Hour).Seconds()), hostStats.LongestTurnSeconds)
+	assert.Equal(t, 1, hostStats.LateTurns)
+
+	rivalStats := stats.Players[1]
+	assert.Equal(t, rival.ID, rivalStats.User.ID)
+	assert.Equal(t, 2, rivalStats.TurnsTaken)
+	assert.Equal(t, int64((2 * time.Hour).Seconds()), rivalStats.MedianTurnSeconds)
+	assert.Equal(t, 0, rivalStats.LateTurns)
+
+	assert.Equal(t, leaver.ID, stats.Players[2].User.ID, "former players keep their stats")
+	assert.Equal(t, 1, stats.Players[2].TurnsTaken)
+
+	assert.Equal(t, http.StatusForbidden, get(t, r, "/api/games/"+g.ID.String()+"/stats", outsiderToken, nil))
+
+	var mine game.UserStats
+	require.Equal(t, http.StatusOK, get(t, r, "/api/user/stats", hostToken, &mine))
+	assert.Equal(t, 1, mine.GamesPlayed)
+	assert.Equal(t, 3, mine.TurnsTaken)
+	assert.Equal(t, int64((30 * time.Hour).Seconds()), mine.LongestTurnSeconds)
+
+	var none game.UserStats
+	require.Equal(t, http.StatusOK, get(t, r, "/api/user/stats", outsiderToken, &none))
+	assert.Equal(t, game.UserStats{}, none)
+}
+
+func TestSimultaneousStats(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "stats-sim-host@example.com")
+	rival, _ := tests.CreateTestUser(db, "stats-sim-rival@example.com")
+	hostToken, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+
+	start := time.Now().Add(-5 * 24 * time.Hour)
+	g := game.Game{
+		Name:      "Simultaneous Stats Game - " + uuid.New().String(),
+		CreatorID: host.ID,
+		Status:    game.GameStatusActive,
+		Settings:  game.GameSettings{TurnMode: game.TurnModeSimultaneous, TurnDeadlineHours: 24},
+		CreatedAt: start,
+	}
+	require.NoError(t, db.Create(&g).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, Role: game.RoleOwner}).Error)
+	require.NoError(t, db.Create(&game.Player{UserID: rival.ID, GameID: g.ID, TurnOrder: 1}).Error)
+
+	// Round 2 starts when the rival finishes round 1. Both then play it at
+	// the same time: the host takes 2h, the rival 30h.
+	save := func(user uuid.UUID, round int, at time.Time) {
+		require.NoError(t, db.Create(&game.Save{GameID: g.ID, UploadedBy: user, FilePath: "unused", Round: round, CreatedAt: at}).Error)
+	}
+	save(host.ID, 1, start.Add(time.Hour))
+	save(rival.ID, 1, start.Add(5*time.Hour))
+	roundTwo := start.Add(6 * time.Hour)
+	require.NoError(t, db.Create(&game.GameEvent{GameID: g.ID, Type: "round_advanced", Data: []byte(`{"round":2}`), CreatedAt: roundTwo}).Error)
+	save(host.ID, 2, roundTwo.Add(2*time.Hour))
+	save(rival.ID, 2, roundTwo.Add(30*time.Hour))
+
+	var stats game.GameStats
+	require.Equal(t, http.StatusOK, get(t, r, "/api/games/"+g.ID.String()+"/stats", hostToken, &stats))
+	require.Len(t, stats.Players, 2)
+	assert.Equal(t, 2, stats.Players[0].TurnsTaken)
+	assert.Equal(t, int64((2 * time.Hour).Seconds()), stats.Players[0].LongestTurnSeconds, "turns are timed from the start of the round")
+	assert.Equal(t, 0, stats.Players[0].LateTurns)
+	assert.Equal(t, int64((30 * time.Hour).Seconds()), stats.Players[1].LongestTurnSeconds)
+	assert.Equal(t, 1, stats.Players[1].LateTurns)
+
+	var mine game.UserStats
+	require.Equal(t, http.StatusOK, get(t, r, "/api/user/stats", hostToken, &mine))
+	assert.Equal(t, int64((2 * time.Hour).Seconds()), mine.LongestTurnSeconds)
+}
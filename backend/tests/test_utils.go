@@ -10,8 +10,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
@@ -44,13 +44,35 @@ func (m *MockNotifier) Notify(recipientEmail string, subject string, body string
 }
 
 // MockSSEManager is a mock implementation of the sse.Broadcaster interface.
-type MockSSEManager struct{}
+type MockSSEManager struct {
+	// LastEvent holds the type of the last broadcast event.
+	LastEvent string
+	// LastData holds the payload of the last broadcast event.
+	LastData interface{}
+	// LastEventID holds the ID of the last event sent with SendEvent.
+	LastEventID string
+	// LastRecipients holds the users the last event was sent to; nil means
+	// every client.
+	LastRecipients []string
+}
+
+// BroadcastMessage records the last event for the mock manager.
+func (m *MockSSEManager) BroadcastMessage(eventType string, data interface{}) {
+	m.LastEvent = eventType
+	m.LastData = data
+	m.LastEventID = ""
+	m.LastRecipients = nil
+}
 
-// BroadcastMessage is a no-op for the mock manager.
-func (m *MockSSEManager) BroadcastMessage(eventType string, data interface{}) {}
+// SendEvent records the last event for the mock manager.
+func (m *MockSSEManager) SendEvent(userIDs []string, id, eventType string, data interface{}) {
+	m.BroadcastMessage(eventType, data)
+	m.LastEventID = id
+	m.LastRecipients = userIDs
+}
 
 // AddClient is a no-op for the mock manager.
-func (m *MockSSEManager) AddClient(client chan string) {}
+func (m *MockSSEManager) AddClient(client chan string, userID string) {}
 
 // RemoveClient is a no-op for the mock manager.
 func (m *MockSSEManager) RemoveClient(client chan string) {}
@@ -63,22 +85,23 @@ var jwtKey = []byte("test_secret_key")
 func SetupTestDB(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 	require.NoError(t, err)
-	db.AutoMigrate(&game.User{}, &game.Game{}, &game.Player{}, &game.Save{})
+	db.AutoMigrate(&game.User{}, &game.UserIdentity{}, &game.Game{}, &game.Player{}, &game.Save{}, &game.RefreshToken{}, &game.RevokedToken{}, &game.MagicLink{}, &game.LoginCode{}, &game.Invitation{}, &game.JoinRequest{}, &game.ChatMessage{}, &game.ChatReaction{}, &game.GameEvent{})
 	return db
 }
 
 func SetupRouter(db *gorm.DB, cfg config.Config, notifier game.Notifier) *gin.Engine {
 	r := gin.Default()
 	sseManager := sse.NewSSEManager()
-	r.POST("/create-game", game.CreateGameHandler(db))
-	r.POST("/join-game/:id", game.JoinGameHandler(db))
-	r.GET("/games/:id", game.GetGameHandler(db))
-	r.GET("/auth/google/login", game.GoogleLoginHandler)
-	r.GET("/auth/google/callback", game.GoogleCallbackHandler(db, cfg))
+	r.POST("/create-game", game.CreateGameHandler(db, cfg, notifier))
+	r.POST("/join-game/:id", game.AuthMiddleware(db, cfg), game.JoinGameHandler(db, cfg, sseManager))
+	r.GET("/games/:id", game.AuthMiddleware(db, cfg), game.GetGameHandler(db, cfg))
+	r.GET("/auth/:provider/login", game.ProviderLoginHandler(cfg))
+	r.GET("/auth/:provider/callback", game.ProviderCallbackHandler(db, cfg))
 
 	// Group save-related routes
 	savesGroup := r.Group("/games/:id/saves")
 	savesGroup.POST("", game.UploadSaveHandler(db, sseManager, notifier))
+	savesGroup.GET("", game.GetSavesHandler(db))
 	savesGroup.GET("/latest", game.GetLatestSaveHandler(db))
 	return r
 }
@@ -120,34 +143,87 @@ func SetupTestEnvironment() (*gorm.DB, *gin.Engine, config.Config, error) {
 	}
 
 	// Auto-migrate the schema
-	if err := db.AutoMigrate(&game.User{}, &game.Game{}, &game.Player{}, &game.Save{}); err != nil {
+	if err := db.AutoMigrate(&game.User{}, &game.UserIdentity{}, &game.Game{}, &game.Player{}, &game.Save{}, &game.RefreshToken{}, &game.RevokedToken{}, &game.MagicLink{}, &game.LoginCode{}, &game.Invitation{}, &game.JoinRequest{}, &game.ChatMessage{}, &game.ChatReaction{}, &game.GameEvent{}); err != nil {
 		return nil, nil, config.Config{}, err
 	}
 
 	// Set up the Gin router
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxyList()); err != nil {
+		return nil, nil, config.Config{}, err
+	}
 	sseManager := &MockSSEManager{}
+	// In a real test setup, you would pass a mock notifier here.
+	// For now, we'll use the actual notifier but this setup allows for mocking.
+	notifier := game.NewMailgunNotifier(cfg)
 
 	// Public routes
-	r.POST("/create-game", game.AuthMiddleware(cfg), game.CreateGameHandler(db))
-	r.POST("/join-game/:id", game.AuthMiddleware(cfg), game.JoinGameHandler(db))
-	r.GET("/games/:id", game.GetGameHandler(db))
-	r.GET("/auth/google/login", game.GoogleLoginHandler)
-	r.GET("/auth/google/callback", game.GoogleCallbackHandler(db, cfg))
+	r.POST("/create-game", game.AuthMiddleware(db, cfg), game.CreateGameHandler(db, cfg, notifier))
+	r.POST("/join-game/:id", game.AuthMiddleware(db, cfg), game.JoinGameHandler(db, cfg, sseManager))
+	r.GET("/games/:id", game.AuthMiddleware(db, cfg), game.GetGameHandler(db, cfg))
+	r.GET("/users/:id/avatar", game.GetAvatarHandler(db))
+	r.GET("/auth/:provider/login", game.ProviderLoginHandler(cfg))
+	r.GET("/auth/:provider/callback", game.ProviderCallbackHandler(db, cfg))
+	r.POST("/auth/email/login", game.RequestMagicLinkHandler(db, cfg, notifier))
+	r.GET("/auth/email/callback", game.MagicLinkCallbackHandler(db, cfg))
+	r.POST("/auth/email/callback", game.ConfirmMagicLinkHandler(db, cfg))
+	r.POST("/auth/token", game.ExchangeLoginCodeHandler(db, cfg))
+	r.POST("/auth/refresh", game.RefreshHandler(db, cfg))
+	r.POST("/auth/logout", game.AuthMiddleware(db, cfg), game.LogoutHandler(db, cfg))
 
 	// Authenticated routes
 	authed := r.Group("/api")
-	authed.Use(game.AuthMiddleware(cfg))
+	authed.Use(game.AuthMiddleware(db, cfg))
 	authed.GET("/user/games", game.GetUserGamesHandler(db))
+	authed.GET("/user/stats", game.GetUserStatsHandler(db))
+	authed.GET("/user/me", game.GetProfileHandler(db))
+	authed.PATCH("/user/me", game.UpdateProfileHandler(db))
+	authed.POST("/user/me/avatar", game.UploadAvatarHandler(db, cfg))
+	authed.GET("/user/identities", game.GetIdentitiesHandler(db))
+	authed.POST("/user/link/:provider", game.LinkIdentityHandler(cfg))
+	authed.DELETE("/user/identities/:identityId", game.UnlinkIdentityHandler(db))
+	authed.POST("/user/merge", game.MergeUsersHandler(db, cfg))
+	authed.GET("/user/invites", game.GetUserInvitesHandler(db))
+	authed.POST("/games/:id/invites", game.CreateInvitesHandler(db, cfg, notifier))
+	authed.GET("/games/:id/invites", game.GetGameInvitesHandler(db))
+	authed.POST("/invites/:inviteId/accept", game.AcceptInviteHandler(db, cfg, sseManager))
+	authed.POST("/invites/:inviteId/decline", game.DeclineInviteHandler(db, cfg))
+	authed.PUT("/games/:id/lobby", game.SetLobbyHandler(db, sseManager))
+	authed.GET("/games/:id/join-requests", game.GetJoinRequestsHandler(db))
+	authed.POST("/games/:id/join-requests/:requestId/approve", game.ApproveJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/join-requests/:requestId/reject", game.RejectJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/spectate", game.SpectateGameHandler(db, cfg, sseManager))
+	authed.POST("/games/:id/leave", game.LeaveGameHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/players/:playerId", game.KickPlayerHandler(db, sseManager, notifier))
+	authed.PUT("/games/:id/turn-order", game.ReorderPlayersHandler(db, sseManager))
+	authed.PUT("/games/:id/players/:playerId/role", game.SetPlayerRoleHandler(db, sseManager))
+	authed.POST("/games/:id/transfer-ownership", game.TransferOwnershipHandler(db, sseManager))
+	authed.POST("/games/:id/start", game.StartGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/pause", game.PauseGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/resume", game.ResumeGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/finish", game.FinishGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/archive", game.ArchiveGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/players/:playerId/substitute", game.SubstitutePlayerHandler(db, sseManager, notifier))
+	authed.PUT("/games/:id/away", game.SetAwayHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/away", game.ClearAwayHandler(db, sseManager))
 	authed.DELETE("/games/:id", game.DeleteGameHandler(db))
+	authed.POST("/games/:id/restore", game.RestoreGameHandler(db, cfg))
+	authed.PATCH("/games/:id", game.UpdateGameHandler(db, sseManager))
+	authed.GET("/games/:id/events", game.GetGameEventsHandler(db))
+	authed.GET("/games/:id/stats", game.GetGameStatsHandler(db))
+	authed.GET("/games/:id/messages", game.GetMessagesHandler(db))
+	authed.POST("/games/:id/messages", game.MessageHandler(db, sseManager, notifier))
+	authed.PATCH("/games/:id/messages/:messageId", game.EditMessageHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/messages/:messageId", game.DeleteMessageHandler(db, sseManager))
+	authed.PUT("/games/:id/messages/read", game.MarkMessagesReadHandler(db, sseManager))
+	authed.POST("/games/:id/messages/:messageId/reactions", game.AddReactionHandler(db, sseManager))
+	authed.DELETE("/games/:id/messages/:messageId/reactions/:emoji", game.RemoveReactionHandler(db, sseManager))
 
 	// Group save-related routes
 	savesGroup := r.Group("/games/:id/saves")
-	savesGroup.Use(game.AuthMiddleware(cfg))
-	// In a real test setup, you would pass a mock notifier here.
-	// For now, we'll use the actual notifier but this setup allows for mocking.
-	notifier := game.NewMailgunNotifier(cfg)
+	savesGroup.Use(game.AuthMiddleware(db, cfg))
 	savesGroup.POST("", game.UploadSaveHandler(db, sseManager, notifier))
+	savesGroup.GET("", game.GetSavesHandler(db))
 	savesGroup.GET("/latest", game.GetLatestSaveHandler(db))
 
 	return db, r, cfg, nil
@@ -173,15 +249,18 @@ func CreateTestUser(db *gorm.DB, email string) (*game.User, error) {
 
 // GetTestUserToken generates a JWT token for a test user.
 func GetTestUserToken(userID uuid.UUID, email string, cfg config.Config) (string, error) {
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims := jwt.MapClaims{
-		"sub":   userID.String(),
-		"email": email,
-		"exp":   expirationTime.Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JwtSecret))
+	now := time.Now()
+	return game.SignToken(cfg, game.Claims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   userID.String(),
+			Issuer:    cfg.JwtIssuer,
+			Audience:  jwt.ClaimStrings{cfg.JwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	})
 }
 
 // SetupTestEnvironmentWithNotifier initializes the database and router for testing with a custom notifier.
@@ -211,30 +290,81 @@ func SetupTestEnvironmentWithNotifier(t *testing.T, notifier game.Notifier) (*go
 	require.NoError(t, err)
 
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&game.User{}, &game.Game{}, &game.Player{}, &game.Save{})
+	err = db.AutoMigrate(&game.User{}, &game.UserIdentity{}, &game.Game{}, &game.Player{}, &game.Save{}, &game.RefreshToken{}, &game.RevokedToken{}, &game.MagicLink{}, &game.LoginCode{}, &game.Invitation{}, &game.JoinRequest{}, &game.ChatMessage{}, &game.ChatReaction{}, &game.GameEvent{})
 	require.NoError(t, err)
 
 	// Set up the Gin router
 	r := gin.Default()
+	require.NoError(t, r.SetTrustedProxies(cfg.TrustedProxyList()))
 	sseManager := &MockSSEManager{}
 
 	// Public routes
-	r.POST("/create-game", game.AuthMiddleware(cfg), game.CreateGameHandler(db))
-	r.POST("/join-game/:id", game.AuthMiddleware(cfg), game.JoinGameHandler(db))
-	r.GET("/games/:id", game.GetGameHandler(db))
-	r.GET("/auth/google/login", game.GoogleLoginHandler)
-	r.GET("/auth/google/callback", game.GoogleCallbackHandler(db, cfg))
+	r.POST("/create-game", game.AuthMiddleware(db, cfg), game.CreateGameHandler(db, cfg, notifier))
+	r.POST("/join-game/:id", game.AuthMiddleware(db, cfg), game.JoinGameHandler(db, cfg, sseManager))
+	r.GET("/games/:id", game.AuthMiddleware(db, cfg), game.GetGameHandler(db, cfg))
+	r.GET("/users/:id/avatar", game.GetAvatarHandler(db))
+	r.GET("/auth/:provider/login", game.ProviderLoginHandler(cfg))
+	r.GET("/auth/:provider/callback", game.ProviderCallbackHandler(db, cfg))
+	r.POST("/auth/email/login", game.RequestMagicLinkHandler(db, cfg, notifier))
+	r.GET("/auth/email/callback", game.MagicLinkCallbackHandler(db, cfg))
+	r.POST("/auth/email/callback", game.ConfirmMagicLinkHandler(db, cfg))
+	r.POST("/auth/token", game.ExchangeLoginCodeHandler(db, cfg))
+	r.POST("/auth/refresh", game.RefreshHandler(db, cfg))
+	r.POST("/auth/logout", game.AuthMiddleware(db, cfg), game.LogoutHandler(db, cfg))
 
 	// Authenticated routes
 	authed := r.Group("/api")
-	authed.Use(game.AuthMiddleware(cfg))
+	authed.Use(game.AuthMiddleware(db, cfg))
 	authed.GET("/user/games", game.GetUserGamesHandler(db))
+	authed.GET("/user/stats", game.GetUserStatsHandler(db))
+	authed.GET("/user/me", game.GetProfileHandler(db))
+	authed.PATCH("/user/me", game.UpdateProfileHandler(db))
+	authed.POST("/user/me/avatar", game.UploadAvatarHandler(db, cfg))
+	authed.GET("/user/identities", game.GetIdentitiesHandler(db))
+	authed.POST("/user/link/:provider", game.LinkIdentityHandler(cfg))
+	authed.DELETE("/user/identities/:identityId", game.UnlinkIdentityHandler(db))
+	authed.POST("/user/merge", game.MergeUsersHandler(db, cfg))
+	authed.GET("/user/invites", game.GetUserInvitesHandler(db))
+	authed.POST("/games/:id/invites", game.CreateInvitesHandler(db, cfg, notifier))
+	authed.GET("/games/:id/invites", game.GetGameInvitesHandler(db))
+	authed.POST("/invites/:inviteId/accept", game.AcceptInviteHandler(db, cfg, sseManager))
+	authed.POST("/invites/:inviteId/decline", game.DeclineInviteHandler(db, cfg))
+	authed.PUT("/games/:id/lobby", game.SetLobbyHandler(db, sseManager))
+	authed.GET("/games/:id/join-requests", game.GetJoinRequestsHandler(db))
+	authed.POST("/games/:id/join-requests/:requestId/approve", game.ApproveJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/join-requests/:requestId/reject", game.RejectJoinRequestHandler(db, sseManager))
+	authed.POST("/games/:id/spectate", game.SpectateGameHandler(db, cfg, sseManager))
+	authed.POST("/games/:id/leave", game.LeaveGameHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/players/:playerId", game.KickPlayerHandler(db, sseManager, notifier))
+	authed.PUT("/games/:id/turn-order", game.ReorderPlayersHandler(db, sseManager))
+	authed.PUT("/games/:id/players/:playerId/role", game.SetPlayerRoleHandler(db, sseManager))
+	authed.POST("/games/:id/transfer-ownership", game.TransferOwnershipHandler(db, sseManager))
+	authed.POST("/games/:id/start", game.StartGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/pause", game.PauseGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/resume", game.ResumeGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/finish", game.FinishGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/archive", game.ArchiveGameHandler(db, sseManager, notifier))
+	authed.POST("/games/:id/players/:playerId/substitute", game.SubstitutePlayerHandler(db, sseManager, notifier))
+	authed.PUT("/games/:id/away", game.SetAwayHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/away", game.ClearAwayHandler(db, sseManager))
 	authed.DELETE("/games/:id", game.DeleteGameHandler(db))
+	authed.POST("/games/:id/restore", game.RestoreGameHandler(db, cfg))
+	authed.PATCH("/games/:id", game.UpdateGameHandler(db, sseManager))
+	authed.GET("/games/:id/events", game.GetGameEventsHandler(db))
+	authed.GET("/games/:id/stats", game.GetGameStatsHandler(db))
+	authed.GET("/games/:id/messages", game.GetMessagesHandler(db))
+	authed.POST("/games/:id/messages", game.MessageHandler(db, sseManager, notifier))
+	authed.PATCH("/games/:id/messages/:messageId", game.EditMessageHandler(db, sseManager, notifier))
+	authed.DELETE("/games/:id/messages/:messageId", game.DeleteMessageHandler(db, sseManager))
+	authed.PUT("/games/:id/messages/read", game.MarkMessagesReadHandler(db, sseManager))
+	authed.POST("/games/:id/messages/:messageId/reactions", game.AddReactionHandler(db, sseManager))
+	authed.DELETE("/games/:id/messages/:messageId/reactions/:emoji", game.RemoveReactionHandler(db, sseManager))
 
 	// Group save-related routes
 	savesGroup := r.Group("/games/:id/saves")
-	savesGroup.Use(game.AuthMiddleware(cfg))
+	savesGroup.Use(game.AuthMiddleware(db, cfg))
 	savesGroup.POST("", game.UploadSaveHandler(db, sseManager, notifier))
+	savesGroup.GET("", game.GetSavesHandler(db))
 	savesGroup.GET("/latest", game.GetLatestSaveHandler(db))
 
 	return db, r, cfg
@@ -0,0 +1,132 @@
+package token_lifecycle
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func refresh(r *gin.Engine, refreshToken string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	req, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	user, err := tests.CreateTestUser(db, "refresh@example.com")
+	require.NoError(t, err)
+	initial, err := game.IssueTokens(db, cfg, *user)
+	require.NoError(t, err)
+
+	t.Run("refresh issues a new pair", func(t *testing.T) {
+		w := refresh(r, initial.RefreshToken)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var rotated game.TokenPair
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+		assert.NotEmpty(t, rotated.AccessToken)
+		assert.NotEqual(t, initial.RefreshToken, rotated.RefreshToken)
+
+		// The new access token is accepted by authenticated routes.
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/user/games", nil)
+		req.Header.Set("Authorization", "Bearer "+rotated.AccessToken)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		t.Run("reusing a rotated token revokes the family", func(t *testing.T) {
+			w := refresh(r, initial.RefreshToken)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+			w = refresh(r, rotated.RefreshToken)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), "revoked")
+		})
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		w := refresh(r, "not-a-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		w := refresh(r, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	user, err := tests.CreateTestUser(db, "logout@example.com")
+	require.NoError(t, err)
+	tokens, err := game.IssueTokens(db, cfg, *user)
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": tokens.RefreshToken})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/logout", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The access token's jti is now on the deny-list.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/user/games", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Token has been revoked")
+
+	// And the refresh token can no longer be used.
+	assert.Equal(t, http.StatusUnauthorized, refresh(r, tokens.RefreshToken).Code)
+}
+
+func TestCookieMode(t *testing.T) {
+	db, _, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+	cfg.AuthCookieMode = true
+
+	r := gin.New()
+	r.GET("/api/user/games", game.AuthMiddleware(db, cfg), game.GetUserGamesHandler(db))
+	r.POST("/auth/refresh", game.RefreshHandler(db, cfg))
+
+	user, err := tests.CreateTestUser(db, "cookie@example.com")
+	require.NoError(t, err)
+	tokens, err := game.IssueTokens(db, cfg, *user)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/user/games", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: tokens.AccessToken})
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Other sites cannot send the auth cookies along with their requests.
+	w = refresh(r, tokens.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	require.NotEmpty(t, cookies)
+	for _, cookie := range cookies {
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite, cookie.Name)
+	}
+}
@@ -0,0 +1,125 @@
+package turn_order
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"panzerstadt/async-multiplayer/game"
+	"panzerstadt/async-multiplayer/tests"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestReorderPlayers(t *testing.T) {
+	db, r, cfg, err := tests.SetupTestEnvironment()
+	require.NoError(t, err)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "reorder-host@example.com")
+	second, _ := tests.CreateTestUser(db, "reorder-second@example.com")
+	third, _ := tests.CreateTestUser(db, "reorder-third@example.com")
+
+	g := game.Game{Name: "Reorder Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	players := []game.Player{
+		{UserID: host.ID, GameID: g.ID, TurnOrder: 0},
+		{UserID: second.ID, GameID: g.ID, TurnOrder: 1},
+		{UserID: third.ID, GameID: g.ID, TurnOrder: 2},
+	}
+	for i := range players {
+		require.NoError(t, db.Create(&players[i]).Error)
+	}
+
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	path := "/api/games/" + g.ID.String() + "/turn-order"
+
+	t.Run("rejects an incomplete list", func(t *testing.T) {
+		body := `{"player_ids":["` + players[0].ID.String() + `","` + players[1].ID.String() + `"]}`
+		assert.Equal(t, http.StatusBadRequest, send(r, "PUT", path, token, body).Code)
+	})
+
+	t.Run("rejects duplicates", func(t *testing.T) {
+		body := `{"player_ids":["` + players[0].ID.String() + `","` + players[0].ID.String() + `","` + players[1].ID.String() + `"]}`
+		assert.Equal(t, http.StatusBadRequest, send(r, "PUT", path, token, body).Code)
+	})
+
+	body := `{"player_ids":["` + players[2].ID.String() + `","` + players[0].ID.String() + `","` + players[1].ID.String() + `"]}`
+	w := send(r, "PUT", path, token, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var reordered []game.Player
+	require.NoError(t, db.Where("game_id = ?", g.ID).Order("turn_order ASC").Find(&reordered).Error)
+	assert.Equal(t, third.ID, reordered[0].UserID)
+	assert.Equal(t, host.ID, reordered[1].UserID)
+	assert.Equal(t, second.ID, reordered[2].UserID)
+}
+
+func TestSubstitutePlayer(t *testing.T) {
+	mockNotifier := tests.NewMockNotifier()
+	db, r, cfg := tests.SetupTestEnvironmentWithNotifier(t, mockNotifier)
+	defer tests.TeardownTestEnvironment(db)
+
+	host, _ := tests.CreateTestUser(db, "sub-host@example.com")
+	dropout, _ := tests.CreateTestUser(db, "sub-dropout@example.com")
+	friend, _ := tests.CreateTestUser(db, "sub-friend@example.com")
+
+	awayUntil := time.Now().Add(72 * time.Hour)
+	g := game.Game{Name: "Substitute Game - " + uuid.New().String(), CreatorID: host.ID}
+	require.NoError(t, db.Create(&g).Error)
+	// The dropout is an admin on holiday who also covers for the host.
+	hostSeat := game.Player{UserID: host.ID, GameID: g.ID, TurnOrder: 0, AwayUntil: &awayUntil, AwaySubstituteID: &dropout.ID}
+	require.NoError(t, db.Create(&hostSeat).Error)
+	readAt := time.Now()
+	seat := game.Player{UserID: dropout.ID, GameID: g.ID, TurnOrder: 1, Role: game.RoleAdmin,
+		AwayUntil: &awayUntil, AwaySubstituteID: &host.ID, LastReadAt: &readAt}
+	require.NoError(t, db.Create(&seat).Error)
+	require.NoError(t, db.Model(&g).Update("current_turn_id", seat.ID).Error)
+	save := game.Save{GameID: g.ID, FilePath: "saves/old.zip", UploadedBy: dropout.ID, PlayerID: &seat.ID}
+	require.NoError(t, db.Create(&save).Error)
+
+	token, err := tests.GetTestUserToken(host.ID, host.Email, cfg)
+	require.NoError(t, err)
+	path := "/api/games/" + g.ID.String() + "/players/" + seat.ID.String() + "/substitute"
+
+	w := send(r, "POST", path, token, `{"email":"`+host.Email+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code, "existing players cannot take a second seat")
+
+	w = send(r, "POST", path, token, `{"email":"`+friend.Email+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var taken game.Player
+	require.NoError(t, db.First(&taken, "id = ?", seat.ID).Error)
+	assert.Equal(t, friend.ID, taken.UserID)
+	assert.Equal(t, 1, taken.TurnOrder)
+	assert.Equal(t, game.RolePlayer, taken.Role, "admin rights stay with the previous user")
+	assert.Nil(t, taken.AwayUntil)
+	assert.Nil(t, taken.AwaySubstituteID)
+	assert.Nil(t, taken.LastReadAt)
+
+	var covered game.Player
+	require.NoError(t, db.First(&covered, "id = ?", hostSeat.ID).Error)
+	assert.Nil(t, covered.AwaySubstituteID, "the previous user no longer covers for anyone")
+
+	require.NoError(t, db.First(&g, "id = ?", g.ID).Error)
+	assert.Equal(t, seat.ID, *g.CurrentTurnID, "the seat keeps its turn")
+
+	var history []game.Save
+	require.NoError(t, db.Where("player_id = ?", seat.ID).Find(&history).Error)
+	assert.Len(t, history, 1)
+	assert.Equal(t, friend.Email, mockNotifier.LastRecipientEmail)
+}
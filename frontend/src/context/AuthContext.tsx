@@ -44,17 +44,30 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     if (storedUser) {
       setUser(JSON.parse(storedUser));
     } else {
+      // After logging in, the backend redirects here with a one-time code
+      // that is exchanged for the tokens.
       const urlParams = new URLSearchParams(window.location.search);
-      const token = urlParams.get("token");
-      if (token) {
-        try {
-          const decodedToken = JSON.parse(atob(token.split(".")[1]));
-          const userData = { id: decodedToken.sub, email: decodedToken.email || "", token };
-          login(userData);
-          window.history.replaceState({}, document.title, window.location.pathname);
-        } catch (e) {
-          console.error("Failed to decode token or parse user data:", e);
-        }
+      const code = urlParams.get("code");
+      if (code) {
+        window.history.replaceState({}, document.title, window.location.pathname);
+        fetch(`${process.env.NEXT_PUBLIC_API_URL}/auth/token`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ code }),
+        })
+          .then((response) => {
+            if (!response.ok) {
+              throw new Error(`login code rejected with status ${response.status}`);
+            }
+            return response.json();
+          })
+          .then(({ access_token: token }) => {
+            const decodedToken = JSON.parse(atob(token.split(".")[1]));
+            login({ id: decodedToken.sub, email: decodedToken.email || "", token });
+          })
+          .catch((e) => {
+            console.error("Failed to complete login:", e);
+          });
       }
     }
     setIsReady(true);
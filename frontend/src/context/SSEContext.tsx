@@ -14,7 +14,15 @@ export function SSEProvider({ children }: { children: ReactNode }) {
 
   useEffect(() => {
     console.log("Attempting to connect to SSE endpoint...");
-    const newEventSource = new EventSource(`${process.env.NEXT_PUBLIC_API_URL}/sse/notifications`);
+    // EventSource cannot send an Authorization header, so the token goes in the query.
+    const user = localStorage.getItem("user");
+    const token = user ? JSON.parse(user).token : undefined;
+    if (!token) {
+      return;
+    }
+    const newEventSource = new EventSource(
+      `${process.env.NEXT_PUBLIC_API_URL}/sse/notifications?token=${encodeURIComponent(token)}`
+    );
     setEventSource(newEventSource);
 
     newEventSource.onopen = () => {
@@ -30,10 +38,10 @@ export function SSEProvider({ children }: { children: ReactNode }) {
       });
     });
 
-    newEventSource.addEventListener("broadcast", ({ data }) => {
+    newEventSource.addEventListener("chat_message", ({ data }) => {
       const parsed = JSON.parse(data);
       toast(() => (
-        <pre className="text-blue-500 whitespace-pre-wrap">{parsed.replace("\\n", "\n")}</pre>
+        <pre className="text-blue-500 whitespace-pre-wrap">{`${parsed.author.display_name}: ${parsed.body}`}</pre>
       ));
     });
 